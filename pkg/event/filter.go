@@ -0,0 +1,163 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"fmt"
+
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// FilterVars are the variables a webhook filter expression can reference.
+// They're populated from the incoming SCM webhook payload before a trigger's
+// CEL expression or JSONPath list is evaluated.
+type FilterVars struct {
+	Branch       string
+	Tag          string
+	Author       string
+	FilesChanged []string
+	Labels       []string
+	CommentBody  string
+	TargetBranch string
+}
+
+// asCELMap converts FilterVars to the activation map cel-go expects.
+func (v FilterVars) asCELMap() map[string]interface{} {
+	return map[string]interface{}{
+		"branch":        v.Branch,
+		"tag":           v.Tag,
+		"author":        v.Author,
+		"files_changed": v.FilesChanged,
+		"labels":        v.Labels,
+		"comment_body":  v.CommentBody,
+		"target_branch": v.TargetBranch,
+	}
+}
+
+// filterEnv declares the variables available to webhook filter expressions.
+var filterEnv, _ = cel.NewEnv(
+	cel.Declarations(
+		decls.NewVar("branch", decls.String),
+		decls.NewVar("tag", decls.String),
+		decls.NewVar("author", decls.String),
+		decls.NewVar("files_changed", decls.NewListType(decls.String)),
+		decls.NewVar("labels", decls.NewListType(decls.String)),
+		decls.NewVar("comment_body", decls.String),
+		decls.NewVar("target_branch", decls.String),
+	),
+)
+
+// CompileFilter parses and type-checks a CEL webhook filter expression,
+// returning a reusable evaluator. Call this at pipeline save time so a typo
+// in the expression is rejected immediately instead of silently never
+// triggering the pipeline. An empty expression is valid and compiles to nil.
+func CompileFilter(expression string) (cel.Program, error) {
+	if expression == "" {
+		return nil, nil
+	}
+
+	ast, issues := filterEnv.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid webhook filter expression %q: %v", expression, issues.Err())
+	}
+
+	prg, err := filterEnv.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook filter expression %q: %v", expression, err)
+	}
+
+	return prg, nil
+}
+
+// LintSCMTrigger compiles every configured filter expression on an
+// api.SCMTrigger's subtypes (Push/PullRequest/TagRelease/PullRequestComment)
+// without evaluating them, so CreatePipeline/UpdatePipeline can reject an
+// invalid expression before it's persisted rather than silently never
+// triggering the pipeline later.
+func LintSCMTrigger(trigger *api.SCMTrigger) error {
+	if trigger == nil {
+		return nil
+	}
+
+	subtypes := map[string]*api.WebhookFilter{}
+	if trigger.Push != nil {
+		subtypes["push"] = trigger.Push.Filter
+	}
+	if trigger.PullRequest != nil {
+		subtypes["pullRequest"] = trigger.PullRequest.Filter
+	}
+	if trigger.TagRelease != nil {
+		subtypes["tagRelease"] = trigger.TagRelease.Filter
+	}
+	if trigger.PullRequestComment != nil {
+		subtypes["pullRequestComment"] = trigger.PullRequestComment.Filter
+	}
+
+	for name, filter := range subtypes {
+		if filter == nil || filter.Expression == "" {
+			continue
+		}
+		if _, err := CompileFilter(filter.Expression); err != nil {
+			return fmt.Errorf("%s trigger: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// MatchWebhookFilter reports whether the given trigger subtype's filter (CEL
+// expression and/or JSONPath list) matches the incoming webhook payload. A
+// subtype with no filter configured always matches, preserving the existing
+// behavior of triggering on any event of a subscribed type. The webhook
+// dispatcher calls this before creating a pipeline record so non-matching
+// events never create one.
+func MatchWebhookFilter(filter *api.WebhookFilter, vars FilterVars, rawPayload interface{}) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+
+	if filter.Expression != "" {
+		prg, err := CompileFilter(filter.Expression)
+		if err != nil {
+			return false, err
+		}
+		out, _, err := prg.Eval(vars.asCELMap())
+		if err != nil {
+			return false, fmt.Errorf("evaluate webhook filter expression %q: %v", filter.Expression, err)
+		}
+		matched, ok := out.Value().(bool)
+		if !ok {
+			return false, fmt.Errorf("webhook filter expression %q did not evaluate to a bool", filter.Expression)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	for _, p := range filter.JSONPaths {
+		if _, err := jsonpath.Get(p, rawPayload); err != nil {
+			// No match for this path; the pipeline isn't triggered.
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package integrate implements third-party code quality/security tool
+// integrations (SonarQube, SonarCloud, Trivy, Snyk, ...) behind a single
+// CodeScanProvider interface so a pipeline can be gated on more than one of
+// them at once.
+package integrate
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// CodeScanProvider is implemented by each supported code-scan integration.
+// All methods are keyed on the pipeline/project's own scan-report identity
+// (projectID) so a provider implementation can be reused across pipelines.
+// The resolved api.Integration doc (address/token/...) is passed in by the
+// caller, which already has to load it from the store to find cfg.Name.
+type CodeScanProvider interface {
+	// EnsureProject makes sure a scan project/target exists for the pipeline,
+	// creating it if necessary. Implementations must treat "already exists"
+	// responses from the underlying tool as success.
+	EnsureProject(integration *api.Integration, cfg *api.CodeScanConfig, projectID, projectName string) error
+	// SetPolicy configures the pass/fail policy for the project, e.g. a
+	// SonarQube quality gate or a Trivy/Snyk severity threshold.
+	SetPolicy(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) error
+	// DeleteProject removes the scan project/target, called when its owning
+	// pipeline is deleted.
+	DeleteProject(integration *api.Integration, projectID string) error
+	// FetchReport retrieves the latest scan result for the project. cfg is
+	// the same pipeline-scoped config SetPolicy was called with, so a
+	// provider whose pass/fail gate isn't persisted server-side (Trivy,
+	// Snyk) can apply cfg.SeverityThresholds/Threshold here instead of a
+	// single integration-wide default.
+	FetchReport(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) (*api.CodeScanReport, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[api.IntegrationType]CodeScanProvider{}
+)
+
+// RegisterCodeScanProvider registers a CodeScanProvider for an integration
+// type. Provider implementations call this from an init() function.
+func RegisterCodeScanProvider(t api.IntegrationType, provider CodeScanProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[t] = provider
+}
+
+// GetCodeScanProvider returns the CodeScanProvider registered for t.
+func GetCodeScanProvider(t api.IntegrationType) (CodeScanProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	provider, ok := providers[t]
+	if !ok {
+		return nil, fmt.Errorf("no code scan provider registered for integration type %s", t)
+	}
+
+	return provider, nil
+}
+
+// Scanners returns the list of code scanners configured for a pipeline's code
+// scan stage. It folds the legacy single SonarQube field in alongside the
+// newer Scanners list so existing pipelines keep working unmodified.
+func Scanners(codeScan *api.CodeScan) []api.CodeScanConfig {
+	if codeScan == nil {
+		return nil
+	}
+
+	scanners := make([]api.CodeScanConfig, 0, len(codeScan.Scanners)+1)
+	if codeScan.SonarQube != nil && codeScan.SonarQube.Config != nil && codeScan.SonarQube.Config.Threshold > 0 {
+		scanners = append(scanners, api.CodeScanConfig{
+			Type:      api.IntegrationTypeSonar,
+			Name:      codeScan.SonarQube.Name,
+			Threshold: codeScan.SonarQube.Config.Threshold,
+		})
+	}
+	scanners = append(scanners, codeScan.Scanners...)
+
+	return scanners
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// snykAPIAddress is the fixed Snyk SaaS API address.
+const snykAPIAddress = "https://snyk.io/api/v1"
+
+func init() {
+	RegisterCodeScanProvider(api.IntegrationTypeSnyk, &snykProvider{})
+}
+
+// snykProvider implements CodeScanProvider against the Snyk SaaS API. Snyk
+// projects are created implicitly on first `snyk monitor`/import, so
+// EnsureProject just verifies the configured orgID is reachable.
+type snykProvider struct{}
+
+func (p *snykProvider) EnsureProject(integration *api.Integration, cfg *api.CodeScanConfig, projectID, projectName string) error {
+	snyk := integration.Snyk
+	if snyk == nil {
+		return fmt.Errorf("get snyk integration info failed")
+	}
+
+	_, err := snykRequest(snyk.Token, http.MethodGet, fmt.Sprintf("/org/%s", snyk.OrgID), nil)
+	return err
+}
+
+func (p *snykProvider) SetPolicy(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) error {
+	snyk := integration.Snyk
+	if snyk == nil {
+		return fmt.Errorf("get snyk integration info failed")
+	}
+	if len(cfg.SeverityThresholds) == 0 {
+		return fmt.Errorf("snyk scan requires at least one severity threshold (e.g. high: 0)")
+	}
+
+	body, err := json.Marshal(snykIgnorePolicy(cfg.SeverityThresholds))
+	if err != nil {
+		return err
+	}
+
+	_, err = snykRequest(snyk.Token, http.MethodPut,
+		fmt.Sprintf("/org/%s/project/%s/ignore-policy", snyk.OrgID, projectID), body)
+	if err != nil {
+		log.Errorf("Set snyk policy for project %s failed as %v", projectID, err)
+	}
+	return err
+}
+
+// snykIgnorePolicy builds the ignore-policy payload Snyk's
+// /project/:id/ignore-policy PUT expects: severities whose threshold is 0
+// (no tolerance) are ignored by none, everything else is left unset so Snyk
+// applies its own default policy for it.
+func snykIgnorePolicy(thresholds map[string]int) map[string]interface{} {
+	severities := make([]string, 0, len(thresholds))
+	for severity, max := range thresholds {
+		if max == 0 {
+			severities = append(severities, strings.ToLower(severity))
+		}
+	}
+
+	return map[string]interface{}{
+		"ignorePolicy": map[string]interface{}{
+			"severities": severities,
+		},
+	}
+}
+
+func (p *snykProvider) DeleteProject(integration *api.Integration, projectID string) error {
+	snyk := integration.Snyk
+	if snyk == nil {
+		return fmt.Errorf("get snyk integration info failed")
+	}
+
+	_, err := snykRequest(snyk.Token, http.MethodDelete, fmt.Sprintf("/org/%s/project/%s", snyk.OrgID, projectID), nil)
+	return err
+}
+
+// FetchReport fetches projectID's current issues from Snyk and sets Passed
+// by applying cfg.SeverityThresholds to the per-severity vulnerability
+// counts, the same gating logic as trivyProvider.FetchReport since Snyk, like
+// Trivy, has no persistent server-side pass/fail gate of its own.
+func (p *snykProvider) FetchReport(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) (*api.CodeScanReport, error) {
+	snyk := integration.Snyk
+	if snyk == nil {
+		return nil, fmt.Errorf("get snyk integration info failed")
+	}
+
+	body, err := snykRequest(snyk.Token, http.MethodGet,
+		fmt.Sprintf("/org/%s/project/%s/issues", snyk.OrgID, projectID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Issues struct {
+			Vulnerabilities []struct {
+				Severity string `json:"severity"`
+			} `json:"vulnerabilities"`
+		} `json:"issues"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("parse snyk issues for project %s: %v", projectID, err)
+	}
+
+	counts := map[string]int{}
+	for _, v := range result.Issues.Vulnerabilities {
+		counts[strings.ToLower(v.Severity)]++
+	}
+
+	passed := true
+	for severity, max := range cfg.SeverityThresholds {
+		if counts[strings.ToLower(severity)] > max {
+			passed = false
+		}
+	}
+
+	return &api.CodeScanReport{ProjectID: projectID, Raw: body, Passed: passed}, nil
+}
+
+func snykRequest(token, method, path string, requestBody []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if requestBody != nil {
+		reqBody = bytes.NewReader(requestBody)
+	}
+
+	req, err := http.NewRequest(method, snykAPIAddress+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("snyk API %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
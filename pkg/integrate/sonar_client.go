@@ -0,0 +1,120 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// sonarRequest issues a token-authenticated request against a SonarQube Web
+// API endpoint, shared by both the SonarQube and SonarCloud providers since
+// SonarCloud speaks the same API against a fixed host.
+func sonarRequest(address, token, method, path string, form url.Values) ([]byte, error) {
+	endpoint := strings.TrimSuffix(address, "/") + path
+	var body strings.Reader
+	if form != nil && method == http.MethodPost {
+		body = *strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, endpoint, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(token, "")
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s", respBody)
+	}
+
+	return respBody, nil
+}
+
+func createSonarProject(address, token, projectKey, projectName string) error {
+	_, err := sonarRequest(address, token, http.MethodPost, "/api/projects/create", url.Values{
+		"project": {projectKey},
+		"name":    {projectName},
+	})
+	return err
+}
+
+func setSonarQualityGate(address, token, projectKey string, gateID int) error {
+	_, err := sonarRequest(address, token, http.MethodPost, "/api/qualitygates/select", url.Values{
+		"projectKey": {projectKey},
+		"gateId":     {strconv.Itoa(gateID)},
+	})
+	return err
+}
+
+func deleteSonarProject(address, token, projectKey string) error {
+	_, err := sonarRequest(address, token, http.MethodPost, "/api/projects/delete", url.Values{
+		"project": {projectKey},
+	})
+	return err
+}
+
+func fetchSonarReport(address, token, projectKey string) (*api.CodeScanReport, error) {
+	body, err := sonarRequest(address, token, http.MethodGet,
+		"/api/qualitygates/project_status?projectKey="+url.QueryEscape(projectKey), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	passed, err := sonarQualityGatePassed(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.CodeScanReport{ProjectID: projectKey, Raw: body, Passed: passed}, nil
+}
+
+// sonarQualityGatePassed reports whether a /api/qualitygates/project_status
+// response (shared by SonarQube and SonarCloud, which speak the same Web
+// API) shows a passing quality gate.
+func sonarQualityGatePassed(body []byte) (bool, error) {
+	var status struct {
+		ProjectStatus struct {
+			Status string `json:"status"`
+		} `json:"projectStatus"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return false, fmt.Errorf("parse quality gate status: %v", err)
+	}
+
+	return status.ProjectStatus.Status == "OK", nil
+}
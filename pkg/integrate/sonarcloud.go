@@ -0,0 +1,118 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integrate
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// sonarCloudAddress is the fixed hosted address of SonarCloud; it speaks the
+// same Web API as a self-hosted SonarQube server.
+const sonarCloudAddress = "https://sonarcloud.io"
+
+func init() {
+	RegisterCodeScanProvider(api.IntegrationTypeSonarCloud, &sonarCloudProvider{})
+}
+
+// sonarCloudProvider implements CodeScanProvider against SonarCloud, the
+// hosted version of SonarQube. It reuses the SonarQube Web API client but
+// requires an organization key on every project-scoped call.
+type sonarCloudProvider struct{}
+
+func (p *sonarCloudProvider) EnsureProject(integration *api.Integration, cfg *api.CodeScanConfig, projectID, projectName string) error {
+	sc := integration.SonarCloud
+	if sc == nil {
+		return fmt.Errorf("get sonarcloud integration info failed")
+	}
+
+	_, err := sonarRequest(sonarCloudAddress, sc.Token, http.MethodPost, "/api/projects/create", url.Values{
+		"project":      {projectID},
+		"name":         {projectName},
+		"organization": {sc.Organization},
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			log.Infof("SonarCloud project %s(%s) already exists.", projectName, projectID)
+			return nil
+		}
+		log.Errorf("Create sonarcloud project %s error:%v", projectName, err)
+		return err
+	}
+
+	return nil
+}
+
+func (p *sonarCloudProvider) SetPolicy(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) error {
+	sc := integration.SonarCloud
+	if sc == nil {
+		return fmt.Errorf("get sonarcloud integration info failed")
+	}
+
+	_, err := sonarRequest(sonarCloudAddress, sc.Token, http.MethodPost, "/api/qualitygates/select", url.Values{
+		"projectKey":   {projectID},
+		"gateId":       {strconv.Itoa(cfg.Threshold)},
+		"organization": {sc.Organization},
+	})
+	if err != nil {
+		log.Errorf("Set sonarcloud quality gate %d for project %s failed as %v", cfg.Threshold, projectID, err)
+		return err
+	}
+
+	return nil
+}
+
+func (p *sonarCloudProvider) DeleteProject(integration *api.Integration, projectID string) error {
+	sc := integration.SonarCloud
+	if sc == nil {
+		return fmt.Errorf("get sonarcloud integration info failed")
+	}
+
+	_, err := sonarRequest(sonarCloudAddress, sc.Token, http.MethodPost, "/api/projects/delete", url.Values{
+		"project":      {projectID},
+		"organization": {sc.Organization},
+	})
+	return err
+}
+
+func (p *sonarCloudProvider) FetchReport(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) (*api.CodeScanReport, error) {
+	sc := integration.SonarCloud
+	if sc == nil {
+		return nil, fmt.Errorf("get sonarcloud integration info failed")
+	}
+
+	body, err := sonarRequest(sonarCloudAddress, sc.Token, http.MethodGet,
+		fmt.Sprintf("/api/qualitygates/project_status?projectKey=%s&organization=%s",
+			url.QueryEscape(projectID), url.QueryEscape(sc.Organization)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	passed, err := sonarQualityGatePassed(body)
+	if err != nil {
+		log.Errorf("parse sonarcloud quality gate status for project %s failed as %v", projectID, err)
+	}
+
+	return &api.CodeScanReport{ProjectID: projectID, Raw: body, Passed: passed}, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integrate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+func init() {
+	RegisterCodeScanProvider(api.IntegrationTypeSonar, &sonarQubeProvider{})
+}
+
+// sonarQubeProvider implements CodeScanProvider against a self-hosted
+// SonarQube server's Web API.
+type sonarQubeProvider struct{}
+
+func (p *sonarQubeProvider) EnsureProject(integration *api.Integration, cfg *api.CodeScanConfig, projectID, projectName string) error {
+	sonar := integration.SonarQube
+	if sonar == nil {
+		return fmt.Errorf("get sonarqube integration info failed")
+	}
+
+	err := createSonarProject(sonar.Address, sonar.Token, projectID, projectName)
+	if err != nil {
+		if strings.Contains(err.Error(), "key already exists") {
+			// If project already exist, will return:
+			// {"errors":[{"msg":"Could not create Project, key already exists: project-1"}]}
+			log.Infof("Project %s(%s) already exists.", projectName, projectID)
+			return nil
+		}
+		log.Errorf("Create sonar project %s error:%v", projectName, err)
+		return err
+	}
+
+	return nil
+}
+
+func (p *sonarQubeProvider) SetPolicy(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) error {
+	sonar := integration.SonarQube
+	if sonar == nil {
+		return fmt.Errorf("get sonarqube integration info failed")
+	}
+
+	if err := setSonarQualityGate(sonar.Address, sonar.Token, projectID, cfg.Threshold); err != nil {
+		log.Errorf("Set sonar quality gate %d for project %s failed as %v", cfg.Threshold, projectID, err)
+		return err
+	}
+
+	return nil
+}
+
+func (p *sonarQubeProvider) DeleteProject(integration *api.Integration, projectID string) error {
+	sonar := integration.SonarQube
+	if sonar == nil {
+		return fmt.Errorf("get sonarqube integration info failed")
+	}
+
+	return deleteSonarProject(sonar.Address, sonar.Token, projectID)
+}
+
+func (p *sonarQubeProvider) FetchReport(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) (*api.CodeScanReport, error) {
+	sonar := integration.SonarQube
+	if sonar == nil {
+		return nil, fmt.Errorf("get sonarqube integration info failed")
+	}
+
+	return fetchSonarReport(sonar.Address, sonar.Token, projectID)
+}
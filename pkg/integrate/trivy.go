@@ -0,0 +1,128 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+func init() {
+	RegisterCodeScanProvider(api.IntegrationTypeTrivy, &trivyProvider{})
+}
+
+// trivyProvider implements CodeScanProvider against a Trivy server
+// (`trivy server`), gating on the count of vulnerabilities per severity
+// rather than a persistent per-project quality gate. Trivy has no notion of
+// a registered project, so EnsureProject/DeleteProject are no-ops.
+type trivyProvider struct{}
+
+func (p *trivyProvider) EnsureProject(integration *api.Integration, cfg *api.CodeScanConfig, projectID, projectName string) error {
+	return nil
+}
+
+func (p *trivyProvider) SetPolicy(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) error {
+	if cfg.SeverityThresholds == nil {
+		return fmt.Errorf("trivy scan requires at least one severity threshold (e.g. CRITICAL: 0)")
+	}
+
+	return nil
+}
+
+func (p *trivyProvider) DeleteProject(integration *api.Integration, projectID string) error {
+	return nil
+}
+
+// FetchReport scans projectID against the configured Trivy server and
+// returns a report whose Passed field reflects whether the vulnerability
+// counts per severity stay within cfg.SeverityThresholds, falling back to
+// the integration-wide trivy.DefaultThresholds when cfg configures none
+// (e.g. a pipeline created before per-pipeline thresholds existed).
+func (p *trivyProvider) FetchReport(integration *api.Integration, cfg *api.CodeScanConfig, projectID string) (*api.CodeScanReport, error) {
+	trivy := integration.Trivy
+	if trivy == nil {
+		return nil, fmt.Errorf("get trivy integration info failed")
+	}
+
+	endpoint := strings.TrimSuffix(trivy.Address, "/") + "/twirp/trivy.scanner.v1.Service/Scan"
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"target": projectID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(string(reqBody)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if trivy.Token != "" {
+		req.Header.Set("Trivy-Token", trivy.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("trivy scan for %s returned %d: %s", projectID, resp.StatusCode, body)
+	}
+
+	var result struct {
+		Vulnerabilities []struct {
+			Severity string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, v := range result.Vulnerabilities {
+		counts[strings.ToUpper(v.Severity)]++
+	}
+
+	thresholds := trivy.DefaultThresholds
+	if cfg != nil && len(cfg.SeverityThresholds) > 0 {
+		thresholds = cfg.SeverityThresholds
+	}
+
+	passed := true
+	for severity, max := range thresholds {
+		if counts[severity] > max {
+			passed = false
+		}
+	}
+
+	log.Infof("Trivy scan of %s found %d vulnerabilities", projectID, len(result.Vulnerabilities))
+
+	return &api.CodeScanReport{ProjectID: projectID, Raw: body, Passed: passed}, nil
+}
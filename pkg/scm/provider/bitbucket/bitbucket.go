@@ -0,0 +1,746 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bitbucket implements the SCM provider for Bitbucket, covering both
+// Bitbucket Cloud (api.bitbucket.org) and Bitbucket Server (formerly Stash).
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/scm"
+	"github.com/caicloud/cyclone/pkg/scm/provider"
+	"github.com/caicloud/cyclone/pkg/util/http/errors"
+)
+
+// cloudBaseURL is the fixed API root for Bitbucket Cloud.
+const cloudBaseURL = "https://api.bitbucket.org/2.0"
+
+func init() {
+	scm.RegisterProvider(api.Bitbucket, func(scmCfg *api.SCMConfig) (scm.SCMProvider, error) {
+		return NewBitbucket(scmCfg)
+	})
+}
+
+// Bitbucket represents the SCM provider for Bitbucket Server/Cloud.
+type Bitbucket struct {
+	scmCfg  *api.SCMConfig
+	client  *http.Client
+	isCloud bool
+}
+
+// NewBitbucket creates a Bitbucket provider, detecting whether the configured
+// server is Bitbucket Cloud or a self-hosted Bitbucket Server based on the
+// configured server address.
+func NewBitbucket(scmCfg *api.SCMConfig) (*Bitbucket, error) {
+	if scmCfg == nil {
+		return nil, fmt.Errorf("the scm config is nil")
+	}
+
+	isCloud := scmCfg.Server == "" || strings.Contains(scmCfg.Server, "bitbucket.org")
+
+	return &Bitbucket{
+		scmCfg:  scmCfg,
+		client:  http.DefaultClient,
+		isCloud: isCloud,
+	}, nil
+}
+
+// baseURL returns the API root to use for this Bitbucket instance.
+func (b *Bitbucket) baseURL() string {
+	if b.isCloud {
+		return cloudBaseURL
+	}
+
+	return strings.TrimSuffix(b.scmCfg.Server, "/") + "/rest/api/1.0"
+}
+
+// GetToken gets the token by the username and password of SCM config.
+func (b *Bitbucket) GetToken() (string, error) {
+	return b.scmCfg.Token, nil
+}
+
+// CheckToken checks whether the token has the authority of repo by trying ListRepos with the token.
+func (b *Bitbucket) CheckToken() bool {
+	if _, err := b.ListRepos(); err != nil {
+		return false
+	}
+	return true
+}
+
+// ListRepos lists the repos by the SCM config.
+func (b *Bitbucket) ListRepos() ([]api.Repository, error) {
+	if b.isCloud {
+		return b.listCloudRepos()
+	}
+
+	return b.listServerRepos()
+}
+
+func (b *Bitbucket) listCloudRepos() ([]api.Repository, error) {
+	var result struct {
+		Values []struct {
+			FullName string `json:"full_name"`
+			Links    struct {
+				Clone []struct {
+					Name string `json:"name"`
+					Href string `json:"href"`
+				} `json:"clone"`
+			} `json:"links"`
+		} `json:"values"`
+	}
+
+	if err := b.do(http.MethodGet, b.baseURL()+"/repositories?role=member", nil, &result); err != nil {
+		return nil, err
+	}
+
+	repos := make([]api.Repository, 0, len(result.Values))
+	for _, v := range result.Values {
+		url := ""
+		for _, c := range v.Links.Clone {
+			if c.Name == "https" {
+				url = c.Href
+			}
+		}
+		repos = append(repos, api.Repository{Name: v.FullName, URL: url})
+	}
+
+	return repos, nil
+}
+
+func (b *Bitbucket) listServerRepos() ([]api.Repository, error) {
+	var result struct {
+		Values []struct {
+			Slug    string `json:"slug"`
+			Project struct {
+				Key string `json:"key"`
+			} `json:"project"`
+			Links struct {
+				Clone []struct {
+					Name string `json:"name"`
+					Href string `json:"href"`
+				} `json:"clone"`
+			} `json:"links"`
+		} `json:"values"`
+	}
+
+	if err := b.do(http.MethodGet, b.baseURL()+"/repos", nil, &result); err != nil {
+		return nil, err
+	}
+
+	repos := make([]api.Repository, 0, len(result.Values))
+	for _, v := range result.Values {
+		url := ""
+		for _, c := range v.Links.Clone {
+			if c.Name == "http" {
+				url = c.Href
+			}
+		}
+		repos = append(repos, api.Repository{Name: v.Project.Key + "/" + v.Slug, URL: url})
+	}
+
+	return repos, nil
+}
+
+// ListBranches lists the branches for specified repo.
+func (b *Bitbucket) ListBranches(repo string) ([]string, error) {
+	owner, name := provider.ParseRepoURL(repo)
+
+	var result struct {
+		Values []struct {
+			Name      string `json:"name"`
+			DisplayID string `json:"displayId"`
+		} `json:"values"`
+	}
+
+	path := b.refsPath(owner, name, "branches")
+	if err := b.do(http.MethodGet, path, nil, &result); err != nil {
+		log.Errorf("Fail to list branches for %s", repo)
+		return nil, err
+	}
+
+	branches := make([]string, len(result.Values))
+	for i, v := range result.Values {
+		if v.Name != "" {
+			branches[i] = v.Name
+		} else {
+			branches[i] = v.DisplayID
+		}
+	}
+
+	return branches, nil
+}
+
+// ListTags lists the tags for specified repo.
+func (b *Bitbucket) ListTags(repo string) ([]string, error) {
+	owner, name := provider.ParseRepoURL(repo)
+
+	var result struct {
+		Values []struct {
+			Name      string `json:"name"`
+			DisplayID string `json:"displayId"`
+		} `json:"values"`
+	}
+
+	path := b.refsPath(owner, name, "tags")
+	if err := b.do(http.MethodGet, path, nil, &result); err != nil {
+		log.Errorf("Fail to list tags for %s", repo)
+		return nil, err
+	}
+
+	tags := make([]string, len(result.Values))
+	for i, v := range result.Values {
+		if v.Name != "" {
+			tags[i] = v.Name
+		} else {
+			tags[i] = v.DisplayID
+		}
+	}
+
+	return tags, nil
+}
+
+// refsPath builds the list-refs endpoint for either Bitbucket flavor.
+func (b *Bitbucket) refsPath(owner, name, kind string) string {
+	if b.isCloud {
+		return fmt.Sprintf("%s/repositories/%s/%s/refs/%s", b.baseURL(), owner, name, kind)
+	}
+
+	return fmt.Sprintf("%s/projects/%s/repos/%s/%s", b.baseURL(), owner, name, kind)
+}
+
+// ListDockerfiles lists the Dockerfiles for specified repo.
+func (b *Bitbucket) ListDockerfiles(repo string) ([]string, error) {
+	return nil, errors.ErrorNotImplemented.Error("list bitbucket dockerfiles")
+}
+
+// ListFilesByPattern lists every file in repo whose base name matches glob.
+// Bitbucket has no repository-tree search API comparable to GitLab's (see
+// GitlabV3/V4.ListFilesByPattern), so this isn't implemented.
+func (b *Bitbucket) ListFilesByPattern(repo, pattern string) ([]string, error) {
+	return nil, errors.ErrorNotImplemented.Error("list bitbucket files by pattern")
+}
+
+// CreateWebHook creates webhook for specified repo, registering via the
+// Bitbucket Cloud or Server REST webhook APIs depending on the instance. If
+// a hook with the same URL is already registered it's updated in place
+// rather than duplicated. Bitbucket has no server-side push-branch-filter
+// equivalent to GitLab's, so webHook.BranchFilter is not sent here; it must
+// be evaluated client-side against the incoming payload instead.
+func (b *Bitbucket) CreateWebHook(repoURL string, webHook *scm.WebHook) error {
+	if webHook == nil || len(webHook.Url) == 0 || len(webHook.Events) == 0 {
+		return fmt.Errorf("The webhook %v is not correct", webHook)
+	}
+
+	owner, name := provider.ParseRepoURL(repoURL)
+	events := toBitbucketEvents(webHook.Events, b.isCloud)
+
+	var body interface{}
+	if b.isCloud {
+		body = map[string]interface{}{
+			"description": "Cyclone webhook",
+			"url":         webHook.Url,
+			"active":      true,
+			"events":      events,
+		}
+	} else {
+		body = map[string]interface{}{
+			"name":   "Cyclone webhook",
+			"url":    webHook.Url,
+			"active": true,
+			"events": events,
+		}
+	}
+
+	existingID, err := b.findWebHookID(owner, name, webHook.Url)
+	if err != nil {
+		return err
+	}
+
+	var path string
+	method := http.MethodPost
+	if existingID != "" {
+		method = http.MethodPut
+		if b.isCloud {
+			path = fmt.Sprintf("%s/repositories/%s/%s/hooks/%s", b.baseURL(), owner, name, existingID)
+		} else {
+			path = fmt.Sprintf("%s/projects/%s/repos/%s/webhooks/%s", b.baseURL(), owner, name, existingID)
+		}
+	} else {
+		if b.isCloud {
+			path = fmt.Sprintf("%s/repositories/%s/%s/hooks", b.baseURL(), owner, name)
+		} else {
+			path = fmt.Sprintf("%s/projects/%s/repos/%s/webhooks", b.baseURL(), owner, name)
+		}
+	}
+
+	var result struct {
+		UUID string `json:"uuid"`
+		ID   int    `json:"id"`
+	}
+	if err := b.do(method, path, body, &result); err != nil {
+		log.Errorf("create bitbucket webhook failed: %v", err)
+		return translateWebhookError(err)
+	}
+
+	if existingID != "" {
+		webHook.ID = existingID
+	} else if b.isCloud {
+		webHook.ID = result.UUID
+	} else {
+		webHook.ID = fmt.Sprintf("%d", result.ID)
+	}
+
+	return nil
+}
+
+// DeleteWebHook deletes webhook from specified repo. It matches by
+// webHookID, the ID CreateWebHook recorded on WebHook.ID, falling back to an
+// exact URL match only for webhooks registered before WebHook.ID existed.
+func (b *Bitbucket) DeleteWebHook(repoURL string, webHookID, webHookUrl string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	id := webHookID
+	if id == "" {
+		var err error
+		id, err = b.findWebHookID(owner, name, webHookUrl)
+		if err != nil {
+			return err
+		}
+		if id == "" {
+			return nil
+		}
+	}
+
+	var path string
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/hooks/%s", b.baseURL(), owner, name, id)
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/webhooks/%s", b.baseURL(), owner, name, id)
+	}
+
+	return b.do(http.MethodDelete, path, nil, nil)
+}
+
+func (b *Bitbucket) findWebHookID(owner, name, webHookUrl string) (string, error) {
+	var path string
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/hooks", b.baseURL(), owner, name)
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/webhooks", b.baseURL(), owner, name)
+	}
+
+	var result struct {
+		Values []struct {
+			UUID string `json:"uuid"`
+			ID   int    `json:"id"`
+			URL  string `json:"url"`
+		} `json:"values"`
+	}
+
+	if err := b.do(http.MethodGet, path, nil, &result); err != nil {
+		return "", err
+	}
+
+	for _, v := range result.Values {
+		if strings.HasPrefix(v.URL, webHookUrl) {
+			if b.isCloud {
+				return v.UUID, nil
+			}
+			return fmt.Sprintf("%d", v.ID), nil
+		}
+	}
+
+	return "", nil
+}
+
+// NewTagFromLatest generate a new tag
+func (b *Bitbucket) NewTagFromLatest(tagName, description, commitID, repoURL string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	var path string
+	var body interface{}
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/refs/tags", b.baseURL(), owner, name)
+		body = map[string]interface{}{
+			"name":    tagName,
+			"target":  map[string]string{"hash": commitID},
+			"message": description,
+		}
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/tags", b.baseURL(), owner, name)
+		body = map[string]interface{}{
+			"name":       tagName,
+			"startPoint": commitID,
+			"message":    description,
+		}
+	}
+
+	return b.do(http.MethodPost, path, body, nil)
+}
+
+// GetTemplateType is not yet supported for Bitbucket.
+func (b *Bitbucket) GetTemplateType(repo string) (string, error) {
+	return "", errors.ErrorNotImplemented.Error("get bitbucket template type")
+}
+
+// SetCommitStatus posts a build status to Bitbucket, via the build-status
+// API shared by Bitbucket Cloud and Server. state is one of the canonical
+// values the StatusReporter reports (pending/running/success/failure/error/
+// cancelled); it's translated to Bitbucket's own build status vocabulary.
+func (b *Bitbucket) SetCommitStatus(repoURL, sha, state, targetURL, description string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	var path string
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/commit/%s/statuses/build", b.baseURL(), owner, name, sha)
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/commits/%s/builds", b.baseURL(), owner, name, sha)
+	}
+
+	body := map[string]interface{}{
+		"state":       toBitbucketBuildState(state),
+		"key":         "continuous-integration/cyclone",
+		"name":        "continuous-integration/cyclone",
+		"url":         targetURL,
+		"description": description,
+	}
+
+	return b.do(http.MethodPost, path, body, nil)
+}
+
+// CreateStatus generate a new status for repository, via the build-status
+// API shared by Bitbucket Cloud and Server.
+func (b *Bitbucket) CreateStatus(recordStatus api.Status, targetURL, repoURL, commitSha string) error {
+	body := map[string]interface{}{
+		"state":       toBitbucketState(recordStatus),
+		"key":         "continuous-integration/cyclone",
+		"name":        "continuous-integration/cyclone",
+		"url":         targetURL,
+		"description": "Cyclone pipeline " + string(recordStatus),
+	}
+
+	owner, name := provider.ParseRepoURL(repoURL)
+	var path string
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/commit/%s/statuses/build", b.baseURL(), owner, name, commitSha)
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/commits/%s/builds", b.baseURL(), owner, name, commitSha)
+	}
+
+	log.Error(b.do(http.MethodPost, path, body, nil))
+	return nil
+}
+
+// GetPullRequestSHA gets the latest commit SHA of the given pull request.
+func (b *Bitbucket) GetPullRequestSHA(repoURL string, number int) (string, error) {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	var path string
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d", b.baseURL(), owner, name, number)
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/pull-requests/%d", b.baseURL(), owner, name, number)
+	}
+
+	var result struct {
+		Source struct {
+			Commit struct {
+				Hash string `json:"hash"`
+			} `json:"commit"`
+		} `json:"source"`
+		FromRef struct {
+			LatestCommit string `json:"latestCommit"`
+		} `json:"fromRef"`
+	}
+
+	if err := b.do(http.MethodGet, path, nil, &result); err != nil {
+		return "", err
+	}
+
+	if b.isCloud {
+		return result.Source.Commit.Hash, nil
+	}
+	return result.FromRef.LatestCommit, nil
+}
+
+// GetMergeRequestTargetBranch gets the target branch of the pull request.
+func (b *Bitbucket) GetMergeRequestTargetBranch(repoURL string, number int) (string, error) {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	var path string
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d", b.baseURL(), owner, name, number)
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/pull-requests/%d", b.baseURL(), owner, name, number)
+	}
+
+	var result struct {
+		Destination struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+		} `json:"destination"`
+		ToRef struct {
+			DisplayID string `json:"displayId"`
+		} `json:"toRef"`
+	}
+
+	if err := b.do(http.MethodGet, path, nil, &result); err != nil {
+		return "", err
+	}
+
+	if b.isCloud {
+		return result.Destination.Branch.Name, nil
+	}
+	return result.ToRef.DisplayID, nil
+}
+
+// RetrieveRepoInfo retrieves the repo info used by SVN-style post-commit hooks;
+// Bitbucket has no equivalent so this is not implemented.
+func (b *Bitbucket) RetrieveRepoInfo(repoURL string) (*api.RepoInfo, error) {
+	return nil, errors.ErrorNotImplemented.Error("retrieve Bitbucket repo info")
+}
+
+// CreatePullRequestComment posts a comment on the given pull request, e.g. a
+// rolling CI status comment the pipeline event handler keeps up to date.
+func (b *Bitbucket) CreatePullRequestComment(repoURL string, number int, body string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	var path string
+	var reqBody map[string]interface{}
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d/comments", b.baseURL(), owner, name, number)
+		reqBody = map[string]interface{}{
+			"content": map[string]interface{}{"raw": body},
+		}
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/pull-requests/%d/comments", b.baseURL(), owner, name, number)
+		reqBody = map[string]interface{}{"text": body}
+	}
+
+	return b.do(http.MethodPost, path, reqBody, nil)
+}
+
+// UpsertPullRequestComment edits the pull request comment whose body
+// contains marker in place via PUT, or creates one if none exists yet. This
+// is what keeps a rolling CI status comment "sticky" - one comment updated
+// on every status transition - instead of a new comment per transition.
+func (b *Bitbucket) UpsertPullRequestComment(repoURL string, number int, marker, body string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	id, version, found, err := b.findPullRequestComment(owner, name, number, marker)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return b.CreatePullRequestComment(repoURL, number, body)
+	}
+
+	var path string
+	var reqBody map[string]interface{}
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d/comments/%s", b.baseURL(), owner, name, number, id)
+		reqBody = map[string]interface{}{
+			"content": map[string]interface{}{"raw": body},
+		}
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/pull-requests/%d/comments/%s", b.baseURL(), owner, name, number, id)
+		reqBody = map[string]interface{}{
+			"text":    body,
+			"version": version,
+		}
+	}
+
+	return b.do(http.MethodPut, path, reqBody, nil)
+}
+
+// findPullRequestComment looks up the first pull request comment whose body
+// contains marker, returning its ID and, for Bitbucket Server, the comment
+// version the PUT endpoint requires for optimistic concurrency.
+func (b *Bitbucket) findPullRequestComment(owner, name string, number int, marker string) (id string, version int, found bool, err error) {
+	var path string
+	if b.isCloud {
+		path = fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d/comments", b.baseURL(), owner, name, number)
+	} else {
+		path = fmt.Sprintf("%s/projects/%s/repos/%s/pull-requests/%d/comments", b.baseURL(), owner, name, number)
+	}
+
+	var result struct {
+		Values []struct {
+			ID      int `json:"id"`
+			Version int `json:"version"`
+			Content struct {
+				Raw string `json:"raw"`
+			} `json:"content"`
+			Text string `json:"text"`
+		} `json:"values"`
+	}
+
+	if err := b.do(http.MethodGet, path, nil, &result); err != nil {
+		return "", 0, false, err
+	}
+
+	for _, v := range result.Values {
+		text := v.Text
+		if b.isCloud {
+			text = v.Content.Raw
+		}
+		if strings.Contains(text, marker) {
+			return fmt.Sprintf("%d", v.ID), v.Version, true, nil
+		}
+	}
+
+	return "", 0, false, nil
+}
+
+// SignCloneURL is a no-op: Bitbucket has no private-mode clone-URL signing
+// mechanism equivalent to GitLab's oauth2 token embedding, so cloneURL is
+// returned unchanged.
+func (b *Bitbucket) SignCloneURL(cloneURL string) string {
+	return cloneURL
+}
+
+// do issues an authenticated HTTP request against the Bitbucket API and
+// decodes a JSON response into out (when out is non-nil).
+func (b *Bitbucket) do(method, path string, body interface{}, out interface{}) error {
+	var payload []byte
+	var err error
+	if body != nil {
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, path, strings.NewReader(string(payload)))
+	if err != nil {
+		return err
+	}
+
+	if b.scmCfg.Username != "" {
+		req.SetBasicAuth(b.scmCfg.Username, b.scmCfg.Token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+b.scmCfg.Token)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bitbucket API %s %s returned %d: %s", method, path, resp.StatusCode, respBody)
+	}
+
+	if out != nil && len(respBody) > 0 {
+		return json.Unmarshal(respBody, out)
+	}
+
+	return nil
+}
+
+// translateWebhookError maps Bitbucket's permission-denied responses to the
+// same error Cyclone surfaces for GitHub/GitLab.
+func translateWebhookError(err error) error {
+	msg := err.Error()
+	if strings.Contains(msg, "401") || strings.Contains(msg, "403") || strings.Contains(msg, "404") {
+		return errors.ErrorCreateWebhookPermissionDenied.Error(msg)
+	}
+
+	return err
+}
+
+// toBitbucketBuildState translates a canonical StatusReporter state
+// (pending/running/success/failure/error/cancelled) into the build status
+// values Bitbucket's API accepts: INPROGRESS, SUCCESSFUL, FAILED, STOPPED.
+func toBitbucketBuildState(state string) string {
+	switch state {
+	case "pending", "running":
+		return "INPROGRESS"
+	case "success":
+		return "SUCCESSFUL"
+	case "cancelled":
+		return "STOPPED"
+	default:
+		return "FAILED"
+	}
+}
+
+// toBitbucketState maps a Cyclone record status to the Bitbucket build status state.
+func toBitbucketState(status api.Status) string {
+	switch status {
+	case api.Success:
+		return "SUCCESSFUL"
+	case api.Failed, api.Aborted:
+		return "FAILED"
+	default:
+		return "INPROGRESS"
+	}
+}
+
+// toBitbucketEvents maps Cyclone's generic SCM event types to the event names
+// used by Bitbucket Cloud's hook subscription API, or Bitbucket Server's
+// event-key API, respectively.
+func toBitbucketEvents(events []scm.EventType, isCloud bool) []string {
+	names := make([]string, 0, len(events))
+	for _, e := range events {
+		switch e {
+		case scm.PushEventType:
+			if isCloud {
+				names = append(names, "repo:push")
+			} else {
+				names = append(names, "repo:refs_changed")
+			}
+		case scm.PullRequestEventType:
+			if isCloud {
+				names = append(names, "pullrequest:created", "pullrequest:updated")
+			} else {
+				names = append(names, "pr:opened")
+			}
+		case scm.PullRequestCommentEventType:
+			if isCloud {
+				names = append(names, "pullrequest:comment_created")
+			} else {
+				names = append(names, "pr:comment:added")
+			}
+		case scm.TagReleaseEventType:
+			if isCloud {
+				names = append(names, "repo:push")
+			} else {
+				names = append(names, "repo:refs_changed")
+			}
+		default:
+			log.Errorf("The event type %s is not supported by Bitbucket, will be ignored", e)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	gitlabv4 "github.com/xanzy/go-gitlab"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// GitLab auth kinds an SCMConfig.AuthType can select. Plain strings, like
+// scmCfg.APIVersion's "v3"/"v4", rather than a typed enum, so SCMConfig
+// doesn't need a GitLab-specific type.
+const (
+	AuthPersonalAccessToken = "personalAccessToken"
+	AuthProjectAccessToken  = "projectAccessToken"
+	AuthOAuth2              = "oauth2"
+	AuthCIJobToken          = "ciJobToken"
+)
+
+// Authenticator attaches GitLab credentials to outgoing requests. It hides
+// the differences between the token kinds GitLab accepts (personal/project
+// access token, OAuth2 bearer token, CI_JOB_TOKEN) behind one interface, so
+// neither go-gitlab client construction nor the hand-rolled HTTP call in
+// GitlabV3.GetPullRequestSHA need to know which kind is configured, and
+// token refresh (OAuth2 only) happens transparently wherever it's used.
+type Authenticator interface {
+	// NewClient builds a go-gitlab client authenticated as this auth kind,
+	// with opts (e.g. gitlabv4.WithBaseURL) applied on top.
+	NewClient(opts ...gitlabv4.ClientOptionFunc) (*gitlabv4.Client, error)
+	// Do executes req against client, setting whatever auth header this kind
+	// uses and, for OAuth2, retrying once after a refresh if GitLab answers
+	// with 401.
+	Do(client *http.Client, req *http.Request) (*http.Response, error)
+}
+
+// newAuthenticator builds the Authenticator scmCfg.AuthType selects,
+// defaulting to personal-access-token since that's what every SCMConfig
+// predating this field is implicitly configured with. httpClient is the
+// per-instance TLS/proxy client httpclient.go builds; oauth2Auth uses it for
+// its own token-refresh requests instead of http.DefaultClient, so refresh
+// respects the same TLS/proxy config as every other call this provider makes.
+func newAuthenticator(scmCfg *api.SCMConfig, httpClient *http.Client) (Authenticator, error) {
+	switch scmCfg.AuthType {
+	case "", AuthPersonalAccessToken:
+		return &tokenAuth{header: "PRIVATE-TOKEN", token: scmCfg.Token}, nil
+	case AuthProjectAccessToken:
+		// Project/group access tokens (GitLab 13.9+) authenticate exactly
+		// like a personal access token; they only differ in what they're
+		// scoped to.
+		return &tokenAuth{header: "PRIVATE-TOKEN", token: scmCfg.Token}, nil
+	case AuthCIJobToken:
+		return &tokenAuth{header: "JOB-TOKEN", token: scmCfg.Token, jobToken: true}, nil
+	case AuthOAuth2:
+		return &oauth2Auth{scmCfg: scmCfg, httpClient: httpClient}, nil
+	default:
+		return nil, fmt.Errorf("unsupported gitlab auth type %q", scmCfg.AuthType)
+	}
+}
+
+// tokenAuth is a static, non-refreshing credential: a personal access token,
+// a project/group access token, or a CI_JOB_TOKEN handed to a running
+// pipeline job.
+type tokenAuth struct {
+	header   string
+	token    string
+	jobToken bool
+}
+
+func (a *tokenAuth) NewClient(opts ...gitlabv4.ClientOptionFunc) (*gitlabv4.Client, error) {
+	if a.jobToken {
+		return gitlabv4.NewJobClient(a.token, opts...)
+	}
+	return gitlabv4.NewClient(a.token, opts...)
+}
+
+func (a *tokenAuth) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	req.Header.Set(a.header, a.token)
+	return client.Do(req)
+}
+
+// oauth2Auth is an OAuth2 bearer token GitLab expires after a couple of
+// hours. It refreshes against scmCfg.RefreshToken before the token is used
+// and again, once, on an unexpected 401 - and rotates the new access/refresh
+// token pair back onto scmCfg, so whatever owns scmCfg's persistence (it's
+// not this package's job to know how SCMConfig is stored) picks up the
+// rotation the next time it saves it.
+type oauth2Auth struct {
+	scmCfg     *api.SCMConfig
+	httpClient *http.Client
+}
+
+func (a *oauth2Auth) NewClient(opts ...gitlabv4.ClientOptionFunc) (*gitlabv4.Client, error) {
+	if err := a.refreshIfExpired(); err != nil {
+		return nil, err
+	}
+	return gitlabv4.NewOAuthClient(a.scmCfg.Token, opts...)
+}
+
+func (a *oauth2Auth) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if err := a.refreshIfExpired(); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.scmCfg.Token)
+
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The access token expired between refreshIfExpired and the call
+	// actually reaching GitLab; force a refresh and retry exactly once.
+	a.scmCfg.TokenExpiry = time.Time{}
+	if err := a.refreshIfExpired(); err != nil {
+		return resp, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.scmCfg.Token)
+	return client.Do(req)
+}
+
+// refreshIfExpired exchanges scmCfg.RefreshToken for a new access/refresh
+// token pair when the current one is missing or at/past TokenExpiry.
+func (a *oauth2Auth) refreshIfExpired() error {
+	cfg := a.scmCfg
+	if cfg.Token != "" && cfg.TokenExpiry.After(time.Now()) {
+		return nil
+	}
+	if cfg.RefreshToken == "" {
+		return fmt.Errorf("gitlab oauth2 token for %s expired and no refresh token is configured", cfg.Server)
+	}
+
+	token, refreshToken, expiresIn, err := exchangeRefreshToken(cfg, a.httpClient)
+	if err != nil {
+		return fmt.Errorf("refresh gitlab oauth2 token for %s: %v", cfg.Server, err)
+	}
+
+	cfg.Token = token
+	cfg.RefreshToken = refreshToken
+	cfg.TokenExpiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	log.Infof("refreshed gitlab oauth2 token for %s", cfg.Server)
+	return nil
+}
+
+// oauthTokenResponse is GitLab's /oauth/token response body, shared by the
+// initial password-grant exchange (getOauthToken) and refresh-grant renewal.
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// exchangeRefreshToken swaps cfg.RefreshToken for a new access/refresh token
+// pair via GitLab's OAuth2 refresh-token grant, through httpClient rather
+// than http.DefaultClient so a self-hosted GitLab behind a private CA or a
+// proxy (cfg.TLS/cfg.Proxy) is reachable the same way every other request
+// this provider makes is.
+func exchangeRefreshToken(cfg *api.SCMConfig, httpClient *http.Client) (token, refreshToken string, expiresIn int, err error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {cfg.RefreshToken},
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(cfg.Server, "/")+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", "", 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var out oauthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", "", 0, err
+	}
+
+	return out.AccessToken, out.RefreshToken, out.ExpiresIn, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitlab
+
+import (
+	"net/http"
+	"strings"
+
+	gitlabv3 "github.com/xanzy/go-gitlab"
+	gitlabv4 "github.com/xanzy/go-gitlab"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/scm"
+)
+
+// v4APIVersion is the GitLab REST API version GitlabV4 talks to.
+const v4APIVersion = "v4"
+
+func init() {
+	scm.RegisterProvider(api.Gitlab, NewGitlab)
+}
+
+// NewGitlab builds the GitLab SCM provider for scmCfg, dispatching to
+// GitlabV3 or GitlabV4 based on scmCfg.APIVersion. V4 is the default: V3 has
+// been deprecated by GitLab for years and exists here only for servers too
+// old to have V4.
+func NewGitlab(scmCfg *api.SCMConfig) (scm.SCMProvider, error) {
+	switch scmCfg.APIVersion {
+	case "v3":
+		return NewGitlabV3(scmCfg)
+	default:
+		return NewGitlabV4(scmCfg)
+	}
+}
+
+// NewGitlabV3 builds a GitLab provider against the deprecated V3 API. V3
+// predates the Authenticator abstraction's client-construction dispatch
+// (the xanzy/go-gitlab V3 client only ever took a bare token), so it keeps
+// authenticating as a plain PRIVATE-TOKEN/Bearer credential; GetPullRequestSHA's
+// hand-rolled request is the one place V3 still needs an Authenticator, for
+// its Do-with-refresh behavior.
+func NewGitlabV3(scmCfg *api.SCMConfig) (*GitlabV3, error) {
+	httpClient, err := newHTTPClient(scmCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newGitlabV3Client(scmCfg, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newAuthenticator(scmCfg, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GitlabV3{scmCfg: scmCfg, client: client, auth: auth, httpClient: httpClient}, nil
+}
+
+// NewGitlabV4 builds a GitLab provider against the V4 API.
+func NewGitlabV4(scmCfg *api.SCMConfig) (*GitlabV4, error) {
+	httpClient, err := newHTTPClient(scmCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newGitlabV4Client(scmCfg, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GitlabV4{scmCfg: scmCfg, client: client}, nil
+}
+
+// newGitlabV3Client builds a go-gitlab client pointed at scmCfg's server's
+// V3 API endpoint, making requests through httpClient so scmCfg.TLS/Proxy
+// apply to it the same as every other call this provider makes.
+func newGitlabV3Client(scmCfg *api.SCMConfig, httpClient *http.Client) (*gitlabv3.Client, error) {
+	client := gitlabv3.NewClient(httpClient, scmCfg.Token)
+	if err := client.SetBaseURL(strings.TrimSuffix(scmCfg.Server, "/") + "/api/v3"); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// newGitlabV4Client builds a go-gitlab client pointed at scmCfg's server's
+// V4 API endpoint, picking the ClientOptionFunc-based constructor that
+// matches scmCfg.AuthType (PAT/project token vs OAuth2 vs CI_JOB_TOKEN) and
+// making requests through httpClient so scmCfg.TLS/Proxy apply.
+func newGitlabV4Client(scmCfg *api.SCMConfig, httpClient *http.Client) (*gitlabv4.Client, error) {
+	auth, err := newAuthenticator(scmCfg, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := auth.NewClient(
+		gitlabv4.WithBaseURL(strings.TrimSuffix(scmCfg.Server, "/")+"/api/v4"),
+		gitlabv4.WithHTTPClient(httpClient),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
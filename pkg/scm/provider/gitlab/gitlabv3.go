@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/caicloud/nirvana/log"
@@ -35,12 +36,20 @@ import (
 
 // GitlabV3 represents the SCM provider of GitlabV3 with API V3.
 type GitlabV3 struct {
-	scmCfg *api.SCMConfig
-	client *gitlabv3.Client
+	scmCfg     *api.SCMConfig
+	client     *gitlabv3.Client
+	auth       Authenticator
+	httpClient *http.Client
 }
 
-// GetToken gets the token by the username and password of SCM config.
+// GetToken gets the token for SCM config's configured auth kind. Only
+// AuthOAuth2 has a token to fetch (via the legacy password grant); every
+// other auth kind is configured with a token the user already supplied, so
+// it's returned unchanged.
 func (g *GitlabV3) GetToken() (string, error) {
+	if g.scmCfg.AuthType != AuthOAuth2 {
+		return g.scmCfg.Token, nil
+	}
 	return getOauthToken(g.scmCfg)
 }
 
@@ -125,15 +134,18 @@ func (g *GitlabV3) ListTags(repo string) ([]string, error) {
 
 // ListDockerfiles lists the Dockerfiles for specified repo.
 func (g *GitlabV3) ListDockerfiles(repo string) ([]string, error) {
-	// List Dockerfiles in a project with gitlab v3 api is very inefficient.
-	// There is not a proper api can be used to do this with GitLab v3.
-	//
-	// FYI:
-	// https://stackoverflow.com/questions/25127695/search-filenames-with-gitlab-api
-	return nil, errors.ErrorNotImplemented.Error("list gitlab v3 dockerfiles")
+	return g.ListFilesByPattern(repo, "Dockerfile*")
+}
+
+// ListFilesByPattern lists every file in repo whose base name matches glob,
+// e.g. "Jenkinsfile" or "*.dockerfile".
+func (g *GitlabV3) ListFilesByPattern(repo, glob string) ([]string, error) {
+	return listFilesByPattern(g.client, repo, glob)
 }
 
-// CreateWebHook creates webhook for specified repo.
+// CreateWebHook creates webhook for specified repo. If a hook with the same
+// URL is already registered, it's updated in place (branch filter, events)
+// rather than duplicated.
 func (g *GitlabV3) CreateWebHook(repoURL string, webHook *scm.WebHook) error {
 	if webHook == nil || len(webHook.Url) == 0 || len(webHook.Events) == 0 {
 		return fmt.Errorf("The webhook %v is not correct", webHook)
@@ -161,29 +173,79 @@ func (g *GitlabV3) CreateWebHook(repoURL string, webHook *scm.WebHook) error {
 		}
 	}
 	hook.URL = &webHook.Url
+	if webHook.BranchFilter != "" {
+		hook.PushEventsBranchFilter = &webHook.BranchFilter
+	}
 
-	onwer, name := provider.ParseRepoURL(repoURL)
-	_, _, err := g.client.Projects.AddProjectHook(onwer+"/"+name, &hook)
-	log.Error(err)
-	return err
-}
-
-// DeleteWebHook deletes webhook from specified repo.
-func (g *GitlabV3) DeleteWebHook(repoURL string, webHookUrl string) error {
 	owner, name := provider.ParseRepoURL(repoURL)
-	hooks, _, err := g.client.Projects.ListProjectHooks(owner+"/"+name, nil)
+
+	if existing, err := findWebHookByURL(g.client, owner, name, webHook.Url); err == nil && existing != nil {
+		edit := gitlabv3.EditProjectHookOptions{
+			URL:                    hook.URL,
+			PushEvents:             hook.PushEvents,
+			MergeRequestsEvents:    hook.MergeRequestsEvents,
+			NoteEvents:             hook.NoteEvents,
+			TagPushEvents:          hook.TagPushEvents,
+			PushEventsBranchFilter: hook.PushEventsBranchFilter,
+		}
+		updated, _, err := g.client.Projects.EditProjectHook(owner+"/"+name, existing.ID, &edit)
+		if err != nil {
+			return err
+		}
+		webHook.ID = fmt.Sprintf("%d", updated.ID)
+		return nil
+	}
+
+	created, _, err := g.client.Projects.AddProjectHook(owner+"/"+name, &hook)
 	if err != nil {
+		log.Error(err)
 		return err
 	}
+	webHook.ID = fmt.Sprintf("%d", created.ID)
+	return nil
+}
+
+// findWebHookByURL returns the project hook registered at url, or nil if none is.
+func findWebHookByURL(client *gitlabv3.Client, owner, name, url string) (*gitlabv3.ProjectHook, error) {
+	hooks, _, err := client.Projects.ListProjectHooks(owner+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, hook := range hooks {
-		if strings.HasPrefix(hook.URL, webHookUrl) {
-			_, err = g.client.Projects.DeleteProjectHook(owner+"/"+name, hook.ID)
-			return nil
+		if hook.URL == url {
+			return hook, nil
 		}
 	}
 
-	return nil
+	return nil, nil
+}
+
+// DeleteWebHook deletes webhook from specified repo. It matches by
+// webHookID, the ID CreateWebHook recorded on WebHook.ID, falling back to an
+// exact URL match only for webhooks registered before WebHook.ID existed.
+func (g *GitlabV3) DeleteWebHook(repoURL string, webHookID, webHookUrl string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	if webHookID != "" {
+		id, err := strconv.Atoi(webHookID)
+		if err != nil {
+			return fmt.Errorf("invalid webhook id %q: %v", webHookID, err)
+		}
+		_, err = g.client.Projects.DeleteProjectHook(owner+"/"+name, id)
+		return err
+	}
+
+	hook, err := findWebHookByURL(g.client, owner, name, webHookUrl)
+	if err != nil {
+		return err
+	}
+	if hook == nil {
+		return nil
+	}
+
+	_, err = g.client.Projects.DeleteProjectHook(owner+"/"+name, hook.ID)
+	return err
 }
 
 // NewTagFromLatest generate a new tag
@@ -233,20 +295,47 @@ func (g *GitlabV3) GetTemplateType(repo string) (string, error) {
 	return language, nil
 }
 
-// CreateStatus generate a new status for repository.
-func (g *GitlabV3) CreateStatus(recordStatus api.Status, targetURL, repoURL, commitSha string) error {
-	state, description := transStatus(recordStatus)
-
+// SetCommitStatus posts a commit status to GitLab. state is one of the
+// canonical values the StatusReporter reports (pending/running/success/
+// failure/error/cancelled); it's translated to GitLab's own BuildState
+// vocabulary since that doesn't have distinct "failure" and "error" states.
+func (g *GitlabV3) SetCommitStatus(repoURL, sha, state, targetURL, description string) error {
 	owner, project := provider.ParseRepoURL(repoURL)
 	context := "continuous-integration/cyclone"
+	buildState := gitlabv3.BuildState(toGitlabState(state))
 	status := &gitlabv3.SetCommitStatusOptions{
-		State:       gitlabv3.BuildState(state),
+		State:       buildState,
 		Description: &description,
 		TargetURL:   &targetURL,
 		Context:     &context,
 	}
-	_, _, err := g.client.Commits.SetCommitStatus(owner+"/"+project, commitSha, status)
-	log.Error(err)
+	_, _, err := g.client.Commits.SetCommitStatus(owner+"/"+project, sha, status)
+	return err
+}
+
+// toGitlabState translates a canonical StatusReporter state into one GitLab
+// accepts for a commit status: pending, running, success, failed, canceled.
+func toGitlabState(state string) string {
+	switch state {
+	case "pending":
+		return "pending"
+	case "running":
+		return "running"
+	case "success":
+		return "success"
+	case "cancelled":
+		return "canceled"
+	default:
+		return "failed"
+	}
+}
+
+// CreateStatus generate a new status for repository.
+func (g *GitlabV3) CreateStatus(recordStatus api.Status, targetURL, repoURL, commitSha string) error {
+	state, description := transStatus(recordStatus)
+	if err := g.SetCommitStatus(repoURL, commitSha, state, targetURL, description); err != nil {
+		log.Error(err)
+	}
 	return nil
 }
 
@@ -258,16 +347,9 @@ func (g *GitlabV3) GetPullRequestSHA(repoURL string, number int) (string, error)
 	if err != nil {
 		return "", err
 	}
-
-	if len(g.scmCfg.Username) == 0 {
-		req.Header.Set("PRIVATE-TOKEN", g.scmCfg.Token)
-	} else {
-		req.Header.Set("Authorization", "Bearer "+g.scmCfg.Token)
-	}
-
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := g.auth.Do(g.httpClient, req)
 	if err != nil {
 		log.Errorf("Fail to get project merge request as %s", err.Error())
 		return "", err
@@ -317,3 +399,47 @@ func (g *GitlabV3) GetMergeRequestTargetBranch(repoURL string, number int) (stri
 func (g *GitlabV3) RetrieveRepoInfo(url string) (*api.RepoInfo, error) {
 	return nil, errors.ErrorNotImplemented.Error("retrieve GitLab repo info")
 }
+
+// CreatePullRequestComment posts a note on the given merge request, e.g. a
+// rolling CI status comment the pipeline event handler keeps up to date.
+func (g *GitlabV3) CreatePullRequestComment(repoURL string, number int, body string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+	_, _, err := g.client.Notes.CreateMergeRequestNote(owner+"/"+name, number, &gitlabv3.CreateMergeRequestNoteOptions{
+		Body: &body,
+	})
+	return err
+}
+
+// UpsertPullRequestComment edits the merge request note whose body contains
+// marker in place via the notes API, or creates one if none exists yet.
+// This is what keeps a rolling CI status comment "sticky" - one comment
+// updated on every status transition - instead of a new note per transition.
+func (g *GitlabV3) UpsertPullRequestComment(repoURL string, number int, marker, body string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+	project := owner + "/" + name
+
+	notes, _, err := g.client.Notes.ListMergeRequestNotes(project, number, &gitlabv3.ListMergeRequestNotesOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		if strings.Contains(note.Body, marker) {
+			_, _, err := g.client.Notes.UpdateMergeRequestNote(project, number, note.ID, &gitlabv3.UpdateMergeRequestNoteOptions{
+				Body: &body,
+			})
+			return err
+		}
+	}
+
+	_, _, err = g.client.Notes.CreateMergeRequestNote(project, number, &gitlabv3.CreateMergeRequestNoteOptions{
+		Body: &body,
+	})
+	return err
+}
+
+// SignCloneURL embeds g's configured token as credentials in cloneURL, per
+// the shared SignCloneURL helper.
+func (g *GitlabV3) SignCloneURL(cloneURL string) string {
+	return SignCloneURL(cloneURL, g.scmCfg)
+}
@@ -0,0 +1,397 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitlab
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+	gitlabv4 "github.com/xanzy/go-gitlab"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/scm"
+	"github.com/caicloud/cyclone/pkg/scm/provider"
+)
+
+// GitlabV4 represents the SCM provider of GitLab with API V4. Unlike
+// GitlabV3 it never falls back to hand-rolled HTTP requests: every method is
+// backed by xanzy/go-gitlab, which has only supported the V4 API since
+// v0.9x.
+type GitlabV4 struct {
+	scmCfg *api.SCMConfig
+	client *gitlabv4.Client
+}
+
+// GetToken gets the token for SCM config's configured auth kind. Only
+// AuthOAuth2 has a token to fetch (via the legacy password grant); every
+// other auth kind is configured with a token the user already supplied, so
+// it's returned unchanged.
+func (g *GitlabV4) GetToken() (string, error) {
+	if g.scmCfg.AuthType != AuthOAuth2 {
+		return g.scmCfg.Token, nil
+	}
+	return getOauthToken(g.scmCfg)
+}
+
+// CheckToken checks whether the token has the authority of repo by trying ListRepos with the token.
+func (g *GitlabV4) CheckToken() bool {
+	if _, err := g.listReposInner(false); err != nil {
+		return false
+	}
+	return true
+}
+
+// ListRepos lists the repos by the SCM config.
+func (g *GitlabV4) ListRepos() ([]api.Repository, error) {
+	return g.listReposInner(true)
+}
+
+func (g *GitlabV4) listReposInner(listAll bool) ([]api.Repository, error) {
+	opt := &gitlabv4.ListProjectsOptions{
+		ListOptions: gitlabv4.ListOptions{
+			PerPage: provider.ListPerPageOpt,
+		},
+	}
+
+	var allProjects []*gitlabv4.Project
+	for {
+		projects, resp, err := g.client.Projects.ListProjects(opt)
+		if err != nil {
+			return nil, err
+		}
+
+		allProjects = append(allProjects, projects...)
+		if resp.NextPage == 0 || !listAll {
+			break
+		}
+		opt.ListOptions.Page = resp.NextPage
+	}
+
+	repos := make([]api.Repository, len(allProjects))
+	for i, repo := range allProjects {
+		repos[i].Name = repo.PathWithNamespace
+		repos[i].URL = repo.HTTPURLToRepo
+	}
+
+	return repos, nil
+}
+
+// ListBranches lists the branches for specified repo.
+func (g *GitlabV4) ListBranches(repo string) ([]string, error) {
+	branches, _, err := g.client.Branches.ListBranches(repo, nil)
+	if err != nil {
+		log.Errorf("Fail to list branches for %s", repo)
+		return nil, err
+	}
+
+	branchNames := make([]string, len(branches))
+	for i, branch := range branches {
+		branchNames[i] = branch.Name
+	}
+
+	return branchNames, nil
+}
+
+// ListTags lists the tags for specified repo.
+func (g *GitlabV4) ListTags(repo string) ([]string, error) {
+	tags, _, err := g.client.Tags.ListTags(repo, nil)
+	if err != nil {
+		log.Errorf("Fail to list tags for %s", repo)
+		return nil, err
+	}
+
+	tagNames := make([]string, len(tags))
+	for i, tag := range tags {
+		tagNames[i] = tag.Name
+	}
+
+	return tagNames, nil
+}
+
+// ListDockerfiles lists the Dockerfiles for specified repo, via the
+// Repository Tree API, which the V3 API never exposed sanely (see
+// GitlabV3.ListDockerfiles).
+func (g *GitlabV4) ListDockerfiles(repo string) ([]string, error) {
+	return g.ListFilesByPattern(repo, "Dockerfile*")
+}
+
+// ListFilesByPattern lists every file in repo whose base name matches glob,
+// e.g. "Jenkinsfile" or "*.dockerfile".
+func (g *GitlabV4) ListFilesByPattern(repo, glob string) ([]string, error) {
+	return listFilesByPattern(g.client, repo, glob)
+}
+
+// CreateWebHook creates webhook for specified repo. If a hook with the same
+// URL is already registered, it's updated in place (branch filter, events)
+// rather than duplicated.
+func (g *GitlabV4) CreateWebHook(repoURL string, webHook *scm.WebHook) error {
+	if webHook == nil || len(webHook.Url) == 0 || len(webHook.Events) == 0 {
+		return fmt.Errorf("The webhook %v is not correct", webHook)
+	}
+
+	enableState, disableState := true, false
+	// Push event is enable for Gitlab webhook in default, so need to remove this default option.
+	hook := gitlabv4.AddProjectHookOptions{
+		PushEvents: &disableState,
+	}
+
+	for _, e := range webHook.Events {
+		switch e {
+		case scm.PullRequestEventType:
+			hook.MergeRequestsEvents = &enableState
+		case scm.PullRequestCommentEventType:
+			hook.NoteEvents = &enableState
+		case scm.PushEventType:
+			hook.PushEvents = &enableState
+		case scm.TagReleaseEventType:
+			hook.TagPushEvents = &enableState
+		default:
+			log.Errorf("The event type %s is not supported, will be ignored", e)
+			return nil
+		}
+	}
+	hook.URL = &webHook.Url
+	if webHook.BranchFilter != "" {
+		hook.PushEventsBranchFilter = &webHook.BranchFilter
+	}
+
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	if existing, err := findWebHookByURLV4(g.client, owner, name, webHook.Url); err == nil && existing != nil {
+		edit := gitlabv4.EditProjectHookOptions{
+			URL:                    hook.URL,
+			PushEvents:             hook.PushEvents,
+			MergeRequestsEvents:    hook.MergeRequestsEvents,
+			NoteEvents:             hook.NoteEvents,
+			TagPushEvents:          hook.TagPushEvents,
+			PushEventsBranchFilter: hook.PushEventsBranchFilter,
+		}
+		updated, _, err := g.client.Projects.EditProjectHook(owner+"/"+name, existing.ID, &edit)
+		if err != nil {
+			return err
+		}
+		webHook.ID = fmt.Sprintf("%d", updated.ID)
+		return nil
+	}
+
+	created, _, err := g.client.Projects.AddProjectHook(owner+"/"+name, &hook)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	webHook.ID = fmt.Sprintf("%d", created.ID)
+	return nil
+}
+
+// findWebHookByURLV4 returns the project hook registered at url, or nil if none is.
+func findWebHookByURLV4(client *gitlabv4.Client, owner, name, url string) (*gitlabv4.ProjectHook, error) {
+	hooks, _, err := client.Projects.ListProjectHooks(owner+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range hooks {
+		if hook.URL == url {
+			return hook, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DeleteWebHook deletes webhook from specified repo. It matches by
+// webHookID, the ID CreateWebHook recorded on WebHook.ID, falling back to an
+// exact URL match only for webhooks registered before WebHook.ID existed.
+func (g *GitlabV4) DeleteWebHook(repoURL string, webHookID, webHookUrl string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+
+	if webHookID != "" {
+		id, err := strconv.Atoi(webHookID)
+		if err != nil {
+			return fmt.Errorf("invalid webhook id %q: %v", webHookID, err)
+		}
+		_, err = g.client.Projects.DeleteProjectHook(owner+"/"+name, id)
+		return err
+	}
+
+	hook, err := findWebHookByURLV4(g.client, owner, name, webHookUrl)
+	if err != nil {
+		return err
+	}
+	if hook == nil {
+		return nil
+	}
+
+	_, err = g.client.Projects.DeleteProjectHook(owner+"/"+name, hook.ID)
+	return err
+}
+
+// NewTagFromLatest generate a new tag
+func (g *GitlabV4) NewTagFromLatest(tagName, description, commitID, url string) error {
+	owner, name := provider.ParseRepoURL(url)
+	tag := &gitlabv4.CreateTagOptions{
+		TagName: &tagName,
+		Ref:     &commitID,
+		Message: &description,
+	}
+
+	_, _, err := g.client.Tags.CreateTag(owner+"/"+name, tag)
+	log.Error(err)
+	return err
+}
+
+// GetTemplateType detects a repo's predominant language/build system.
+func (g *GitlabV4) GetTemplateType(repo string) (string, error) {
+	languages, err := getLanguages(g.scmCfg, v4APIVersion, repo)
+	if err != nil {
+		log.Error("list language failed:%v", err)
+		return "", err
+	}
+	language := getTopLanguage(languages)
+
+	switch language {
+	case api.JavaRepoType, api.JavaScriptRepoType:
+		files, err := getContents(g.scmCfg, v4APIVersion, repo)
+		if err != nil {
+			log.Error("get contents failed:%v", err)
+			return language, nil
+		}
+
+		for _, f := range files {
+			if language == api.JavaRepoType && strings.Contains(f.Name, "pom.xml") {
+				return api.MavenRepoType, nil
+			}
+			if language == api.JavaRepoType && strings.Contains(f.Name, "build.gradle") {
+				return api.GradleRepoType, nil
+			}
+			if language == api.JavaScriptRepoType && strings.Contains(f.Name, "package.json") {
+				return api.NodeRepoType, nil
+			}
+		}
+	}
+
+	return language, nil
+}
+
+// SetCommitStatus posts a commit status to GitLab.
+func (g *GitlabV4) SetCommitStatus(repoURL, sha, state, targetURL, description string) error {
+	owner, project := provider.ParseRepoURL(repoURL)
+	context := "continuous-integration/cyclone"
+	opt := &gitlabv4.SetCommitStatusOptions{
+		State:       gitlabv4.BuildStateValue(toGitlabState(state)),
+		Description: &description,
+		TargetURL:   &targetURL,
+		Context:     &context,
+	}
+	_, _, err := g.client.Commits.SetCommitStatus(owner+"/"+project, sha, opt)
+	return err
+}
+
+// CreateStatus generate a new status for repository.
+func (g *GitlabV4) CreateStatus(recordStatus api.Status, targetURL, repoURL, commitSha string) error {
+	state, description := transStatus(recordStatus)
+	if err := g.SetCommitStatus(repoURL, commitSha, state, targetURL, description); err != nil {
+		log.Error(err)
+	}
+	return nil
+}
+
+// GetPullRequestSHA gets the latest commit SHA of the given merge request,
+// via the library's MergeRequests API rather than a hand-rolled HTTP call
+// against the deprecated v3 "?iid=" query (see GitlabV3.GetPullRequestSHA).
+func (g *GitlabV4) GetPullRequestSHA(repoURL string, number int) (string, error) {
+	owner, name := provider.ParseRepoURL(repoURL)
+	mr, _, err := g.client.MergeRequests.GetMergeRequest(owner+"/"+name, number, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return mr.SHA, nil
+}
+
+// GetMergeRequestTargetBranch gets the target branch of the given merge request.
+func (g *GitlabV4) GetMergeRequestTargetBranch(repoURL string, number int) (string, error) {
+	owner, name := provider.ParseRepoURL(repoURL)
+	mr, _, err := g.client.MergeRequests.GetMergeRequest(owner+"/"+name, number, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return mr.TargetBranch, nil
+}
+
+// RetrieveRepoInfo retrieves the project's info needed for SVN-style post
+// commit hooks, via the Projects API.
+func (g *GitlabV4) RetrieveRepoInfo(repoURL string) (*api.RepoInfo, error) {
+	owner, name := provider.ParseRepoURL(repoURL)
+	project, _, err := g.client.Projects.GetProject(owner+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.RepoInfo{
+		ID:            fmt.Sprintf("%d", project.ID),
+		Name:          project.Name,
+		DefaultBranch: project.DefaultBranch,
+	}, nil
+}
+
+// CreatePullRequestComment posts a note on the given merge request, e.g. a
+// rolling CI status comment the pipeline event handler keeps up to date.
+func (g *GitlabV4) CreatePullRequestComment(repoURL string, number int, body string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+	_, _, err := g.client.Notes.CreateMergeRequestNote(owner+"/"+name, number, &gitlabv4.CreateMergeRequestNoteOptions{
+		Body: &body,
+	})
+	return err
+}
+
+// UpsertPullRequestComment edits the merge request note whose body contains
+// marker in place via the notes API, or creates one if none exists yet.
+// This is what keeps a rolling CI status comment "sticky" - one comment
+// updated on every status transition - instead of a new note per transition.
+func (g *GitlabV4) UpsertPullRequestComment(repoURL string, number int, marker, body string) error {
+	owner, name := provider.ParseRepoURL(repoURL)
+	project := owner + "/" + name
+
+	notes, _, err := g.client.Notes.ListMergeRequestNotes(project, number, &gitlabv4.ListMergeRequestNotesOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		if strings.Contains(note.Body, marker) {
+			_, _, err := g.client.Notes.UpdateMergeRequestNote(project, number, note.ID, &gitlabv4.UpdateMergeRequestNoteOptions{
+				Body: &body,
+			})
+			return err
+		}
+	}
+
+	_, _, err = g.client.Notes.CreateMergeRequestNote(project, number, &gitlabv4.CreateMergeRequestNoteOptions{
+		Body: &body,
+	})
+	return err
+}
+
+// SignCloneURL embeds g's configured token as credentials in cloneURL, per
+// the shared SignCloneURL helper.
+func (g *GitlabV4) SignCloneURL(cloneURL string) string {
+	return SignCloneURL(cloneURL, g.scmCfg)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitlab
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// newHTTPClient builds the *http.Client a GitLab provider instance makes
+// every request through - both the go-gitlab client and the hand-rolled
+// call in GitlabV3.GetPullRequestSHA - so scmCfg.TLS and scmCfg.Proxy apply
+// uniformly to a self-hosted instance behind a private CA or a proxy,
+// instead of only to whichever call remembered to thread them through.
+func newHTTPClient(scmCfg *api.SCMConfig) (*http.Client, error) {
+	transport := &http.Transport{}
+
+	tlsConfig, err := buildTLSConfig(scmCfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if scmCfg.Proxy != "" {
+		proxyURL, err := url.Parse(scmCfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gitlab proxy url %q: %v", scmCfg.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// buildTLSConfig builds a *tls.Config from scmCfg.TLS, or returns nil if TLS
+// isn't configured (the provider just uses Go's default root cert pool).
+func buildTLSConfig(scmCfg *api.SCMConfig) (*tls.Config, error) {
+	tlsCfg := scmCfg.TLS
+	if tlsCfg == nil {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: tlsCfg.InsecureSkipVerify}
+
+	if len(tlsCfg.CABundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(tlsCfg.CABundle) {
+			return nil, fmt.Errorf("gitlab TLS CA bundle contains no valid certificates")
+		}
+		config.RootCAs = pool
+	}
+
+	if len(tlsCfg.ClientCert) > 0 && len(tlsCfg.ClientKey) > 0 {
+		cert, err := tls.X509KeyPair(tlsCfg.ClientCert, tlsCfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gitlab client cert/key: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// SignCloneURL rewrites a raw git clone URL to embed scmCfg's token as
+// credentials, using the "oauth2:<token>@" form GitLab documents for HTTPS
+// clones, so a worker can clone a private repo without a deploy key. It's a
+// no-op unless scmCfg.PrivateMode is set.
+func SignCloneURL(cloneURL string, scmCfg *api.SCMConfig) string {
+	if scmCfg == nil || !scmCfg.PrivateMode || scmCfg.Token == "" {
+		return cloneURL
+	}
+
+	u, err := url.Parse(cloneURL)
+	if err != nil {
+		return cloneURL
+	}
+
+	u.User = url.UserPassword("oauth2", scmCfg.Token)
+	return u.String()
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitlab
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gobwas/glob"
+	gitlabv3 "github.com/xanzy/go-gitlab"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/scm/provider"
+)
+
+const (
+	// maxTreeDepth bounds how many path segments deep listFilesByPattern
+	// will descend into, so a huge monorepo can't make a single Dockerfile
+	// lookup scan its entire history of vendored/generated subtrees.
+	maxTreeDepth = 10
+
+	// maxTreeEntries bounds the total number of tree entries
+	// listFilesByPattern will collect before giving up on completeness and
+	// returning what it has.
+	maxTreeEntries = 5000
+
+	// treeFetchWorkers is the number of tree pages fetched concurrently
+	// once the first page reveals how many pages there are.
+	treeFetchWorkers = 4
+)
+
+// listFilesByPattern walks repo's repository tree (recursively, with
+// pagination) and returns the full path of every blob entry whose base name
+// matches pattern, a glob like "Dockerfile*" or "*.dockerfile". It's the
+// shared primitive behind both GitlabV3/V4's ListDockerfiles and
+// ListFilesByPattern, since both versions of the API expose the same
+// Repository Tree endpoint shape through the same go-gitlab client type.
+func listFilesByPattern(client *gitlabv3.Client, repo, pattern string) ([]string, error) {
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+	}
+
+	opt := &gitlabv3.ListTreeOptions{
+		Recursive: gitlabv3.Bool(true),
+		ListOptions: gitlabv3.ListOptions{
+			PerPage: provider.ListPerPageOpt,
+			Page:    1,
+		},
+	}
+
+	firstPage, resp, err := client.Repositories.ListTree(repo, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := append([]*gitlabv3.TreeNode{}, firstPage...)
+
+	if resp.TotalPages > 1 {
+		pages, err := fetchRemainingPages(client, repo, opt, resp.TotalPages)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, pages...)
+	}
+
+	var matches []string
+	for i, entry := range entries {
+		if i >= maxTreeEntries {
+			log.Warningf("listFilesByPattern: repo %s has more than %d tree entries, results truncated", repo, maxTreeEntries)
+			break
+		}
+		if entry.Type != "blob" {
+			continue
+		}
+		if treeDepth(entry.Path) > maxTreeDepth {
+			continue
+		}
+		if g.Match(entry.Name) {
+			matches = append(matches, entry.Path)
+		}
+	}
+
+	return matches, nil
+}
+
+// fetchRemainingPages fetches pages 2..totalPages of a repository tree
+// listing concurrently, bounded by a small worker pool, since the first
+// page already reveals the total page count.
+func fetchRemainingPages(client *gitlabv3.Client, repo string, opt *gitlabv3.ListTreeOptions, totalPages int) ([]*gitlabv3.TreeNode, error) {
+	type result struct {
+		page    int
+		entries []*gitlabv3.TreeNode
+		err     error
+	}
+
+	pageCh := make(chan int)
+	resultCh := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < treeFetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pageCh {
+				pageOpt := *opt
+				pageOpt.Page = page
+				entries, _, err := client.Repositories.ListTree(repo, &pageOpt)
+				resultCh <- result{page: page, entries: entries, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for page := 2; page <= totalPages; page++ {
+			pageCh <- page
+		}
+		close(pageCh)
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	byPage := make(map[int][]*gitlabv3.TreeNode, totalPages-1)
+	var firstErr error
+	for res := range resultCh {
+		if res.err != nil && firstErr == nil {
+			firstErr = res.err
+			continue
+		}
+		byPage[res.page] = res.entries
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var entries []*gitlabv3.TreeNode
+	for page := 2; page <= totalPages; page++ {
+		entries = append(entries, byPage[page]...)
+	}
+
+	return entries, nil
+}
+
+// treeDepth returns how many path segments deep a repository tree path is.
+func treeDepth(path string) int {
+	return strings.Count(path, "/") + 1
+}
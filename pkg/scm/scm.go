@@ -0,0 +1,156 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scm defines the SCM provider abstraction Cyclone talks to GitHub,
+// GitLab, Bitbucket, and SVN through.
+package scm
+
+import (
+	"fmt"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// EventType is a webhook event Cyclone can subscribe a pipeline to.
+type EventType string
+
+// Supported webhook event types.
+const (
+	PushEventType               EventType = "push"
+	PullRequestEventType        EventType = "pullRequest"
+	PullRequestCommentEventType EventType = "pullRequestComment"
+	TagReleaseEventType         EventType = "tagRelease"
+)
+
+// WebHook describes the webhook CreateWebHook registers with an SCM.
+//
+// BranchFilter, TagFilter, and PathFilters narrow which pushes/tags actually
+// trigger the pipeline. BranchFilter is passed through to providers that can
+// evaluate it server-side (GitLab's push_events_branch_filter); TagFilter
+// and PathFilters have no server-side equivalent on any provider Cyclone
+// supports, so the webhook receiver evaluates them itself against the
+// incoming payload before creating a pipeline record.
+type WebHook struct {
+	Url    string
+	Events []EventType
+
+	// ID is assigned by the provider once CreateWebHook succeeds, so a
+	// later DeleteWebHook can match the hook to delete by its stable ID
+	// instead of comparing URLs.
+	ID string
+
+	// BranchFilter is a glob (github.com/gobwas/glob syntax) restricting
+	// which branch a push event must target to trigger the pipeline.
+	BranchFilter string
+
+	// TagFilter is a glob restricting which tag name a push/tag-release
+	// event must match to trigger the pipeline.
+	TagFilter string
+
+	// PathFilters restricts push events to commits that touch at least one
+	// path matching one of these globs.
+	PathFilters []string
+}
+
+// SCMProvider is the common interface Cyclone talks to a source control
+// management system through, implemented once per SCM (GitLab, Bitbucket,
+// GitHub, SVN, ...).
+type SCMProvider interface {
+	// GetToken gets the token by the username and password of SCM config.
+	GetToken() (string, error)
+	// CheckToken checks whether the configured token is still valid.
+	CheckToken() bool
+
+	// ListRepos lists the repos accessible to the configured token.
+	ListRepos() ([]api.Repository, error)
+	// ListBranches lists the branches for specified repo.
+	ListBranches(repo string) ([]string, error)
+	// ListTags lists the tags for specified repo.
+	ListTags(repo string) ([]string, error)
+	// ListDockerfiles lists the Dockerfiles for specified repo.
+	ListDockerfiles(repo string) ([]string, error)
+	// ListFilesByPattern lists every file in repo whose base name matches
+	// a glob pattern, e.g. "Jenkinsfile" or "*.dockerfile".
+	ListFilesByPattern(repo, pattern string) ([]string, error)
+
+	// CreateWebHook creates webhook for specified repo. On success it sets
+	// webHook.ID to the provider-assigned hook ID.
+	CreateWebHook(repoURL string, webHook *WebHook) error
+	// DeleteWebHook deletes a previously registered webhook for specified
+	// repo. webHookID, if non-empty, is matched exactly against the
+	// provider's hook ID; it takes precedence over webHookUrl, which is
+	// kept only to delete hooks created before WebHook.ID existed.
+	DeleteWebHook(repoURL string, webHookID, webHookUrl string) error
+
+	// NewTagFromLatest generates a new tag from the latest commit.
+	NewTagFromLatest(tagName, description, commitID, repoURL string) error
+	// GetTemplateType detects a repo's predominant language/build system.
+	GetTemplateType(repo string) (string, error)
+
+	// SetCommitStatus posts a commit status. state is one of the canonical
+	// values pending/running/success/failure/error/cancelled.
+	SetCommitStatus(repoURL, sha, state, targetURL, description string) error
+	// CreateStatus posts a commit status derived from a Cyclone record status.
+	CreateStatus(recordStatus api.Status, targetURL, repoURL, commitSha string) error
+	// CreatePullRequestComment posts a comment on the given pull/merge request.
+	CreatePullRequestComment(repoURL string, number int, body string) error
+	// UpsertPullRequestComment edits the pull/merge request comment whose
+	// body contains marker in place, or creates one if none exists yet -
+	// the "sticky" comment behavior CreatePullRequestComment alone can't
+	// provide, since it always appends a new comment.
+	UpsertPullRequestComment(repoURL string, number int, marker, body string) error
+
+	// GetPullRequestSHA gets the latest commit SHA of the given pull request.
+	GetPullRequestSHA(repoURL string, number int) (string, error)
+	// GetMergeRequestTargetBranch gets the target branch of the given pull request.
+	GetMergeRequestTargetBranch(repoURL string, number int) (string, error)
+
+	// RetrieveRepoInfo retrieves the repo info needed for SVN-style post
+	// commit hooks.
+	RetrieveRepoInfo(repoURL string) (*api.RepoInfo, error)
+
+	// SignCloneURL rewrites a raw git clone URL to embed whatever
+	// credentials a stage container needs to clone it without a deploy key.
+	// It's a no-op for providers/configs that don't support or need this
+	// (e.g. a provider with no private-mode signing, or a public repo).
+	SignCloneURL(cloneURL string) string
+}
+
+// providerFactories are registered by each concrete SCM provider package's
+// init() via RegisterProvider, so this package doesn't need to import any of
+// them (and thus doesn't force every binary linking it to pull in every
+// provider's dependencies).
+var providerFactories = map[api.SCMType]func(*api.SCMConfig) (SCMProvider, error){}
+
+// RegisterProvider registers a factory for scmType. Provider packages call
+// this from their own init().
+func RegisterProvider(scmType api.SCMType, factory func(*api.SCMConfig) (SCMProvider, error)) {
+	providerFactories[scmType] = factory
+}
+
+// GetSCMProvider builds the SCMProvider for scmConfig's type.
+func GetSCMProvider(scmConfig *api.SCMConfig) (SCMProvider, error) {
+	if scmConfig == nil {
+		return nil, fmt.Errorf("SCM config is nil")
+	}
+
+	factory, ok := providerFactories[scmConfig.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SCM type %s", scmConfig.Type)
+	}
+
+	return factory(scmConfig)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caicloud/cyclone/pkg/api"
+)
+
+// BuildPipelineEnvVars returns the well-known CYCLONE_* environment
+// variables every stage container gets, plus deprecated CI_* aliases kept
+// for portability with scripts written against other CI systems (Jenkins,
+// GitLab CI, Travis, ...). pipelineManager.BuildRecordEnvVars calls this once
+// per record and merges the result ahead of the stage's own configured env,
+// so a stage can still override any of these if it really needs to.
+func BuildPipelineEnvVars(pipeline *api.Pipeline, record *api.PipelineRecord, repoURL string) map[string]string {
+	vars := map[string]string{
+		"CYCLONE_PIPELINE_STATUS":   string(record.Status),
+		"CYCLONE_PIPELINE_STARTED":  formatEnvTime(record.StartTime),
+		"CYCLONE_PIPELINE_FINISHED": formatEnvTime(record.EndTime),
+		"CYCLONE_PIPELINE_NUMBER":   fmt.Sprintf("%d", record.Number),
+		"CYCLONE_REPO":              repoURL,
+		"CYCLONE_COMMIT_SHA":        record.CommitSha,
+		"CYCLONE_EVENT":             record.Trigger,
+	}
+
+	// Deprecated aliases, kept for scripts written for other CI systems.
+	aliases := map[string]string{
+		"CI_PIPELINE_STATUS":   vars["CYCLONE_PIPELINE_STATUS"],
+		"CI_PIPELINE_STARTED":  vars["CYCLONE_PIPELINE_STARTED"],
+		"CI_PIPELINE_FINISHED": vars["CYCLONE_PIPELINE_FINISHED"],
+		"CI_PIPELINE_NUMBER":   vars["CYCLONE_PIPELINE_NUMBER"],
+		"CI_REPO":              vars["CYCLONE_REPO"],
+		"CI_COMMIT_SHA":        vars["CYCLONE_COMMIT_SHA"],
+		"CI_EVENT":             vars["CYCLONE_EVENT"],
+	}
+	for k, v := range aliases {
+		vars[k] = v
+	}
+
+	return vars
+}
+
+// formatEnvTime renders a record timestamp for stage container consumption,
+// or "" if it hasn't happened yet (e.g. CYCLONE_PIPELINE_FINISHED while the
+// pipeline is still running).
+func formatEnvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
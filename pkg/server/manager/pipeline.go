@@ -47,8 +47,16 @@ type PipelineManager interface {
 	UpdatePipeline(projectName string, pipelineName string, newPipeline *api.Pipeline) (*api.Pipeline, error)
 	DeletePipeline(projectName string, pipelineName string) error
 	ClearPipelinesOfProject(projectName string) error
-	GetStatistics(projectName, pipelineName string, start, end time.Time) (*api.PipelineStatusStats, error)
+	GetStatistics(projectName, pipelineName string, start, end time.Time, granularity StatsGranularity) (*api.PipelineStatusStats, error)
+	RecordStatsForTerminalRecord(record *api.PipelineRecord) error
+	BuildRecordEnvVars(pipeline *api.Pipeline, record *api.PipelineRecord) (map[string]string, error)
+	ReportRecordStatus(projectName string, pipeline *api.Pipeline, record *api.PipelineRecord, targetURL string) error
 	FindSVNHooksPipelines(repoid string) ([]api.Pipeline, error)
+	CreateTemplate(projectName string, template *api.PipelineTemplate) (*api.PipelineTemplate, error)
+	ListTemplates(projectName string) ([]api.PipelineTemplate, error)
+	RenderTemplate(projectName, templateName string, params map[string]string) (*api.BuildSpec, error)
+	SwitchPipelineType(projectName, pipelineName string, newType api.PipelineType) (*api.Pipeline, error)
+	HandleSCMWebhook(pipelineID string, eventType scm.EventType, vars event.FilterVars, rawPayload interface{}) (matched bool, err error)
 }
 
 // pipelineManager represents the manager for pipeline.
@@ -76,11 +84,29 @@ func NewPipelineManager(dataStore *store.DataStore, pipelineRecordManager Pipeli
 
 	eventManager := event.NewEventManager(dataStore)
 
-	return &pipelineManager{dataStore, pipelineRecordManager, eventManager}, nil
+	m := &pipelineManager{dataStore, pipelineRecordManager, eventManager}
+
+	go m.reconcileInFlightPipelines()
+
+	return m, nil
 }
 
 // CreatePipeline creates a pipeline.
+//
+// Creation is driven as an idempotent saga: the pipeline ID is assigned up
+// front and a PipelineReconcileState doc tracks which steps (webhook, sonar
+// project, quality gate, the final store insert) have completed, so a crash
+// mid-way can be resumed or rolled back by reconcileInFlightPipelines instead
+// of leaving orphan webhooks or Sonar projects behind.
 func (m *pipelineManager) CreatePipeline(projectName string, pipeline *api.Pipeline) (*api.Pipeline, error) {
+	if pipeline.TemplateRef != "" {
+		spec, err := m.RenderTemplate(projectName, pipeline.TemplateRef, pipeline.TemplateParams)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Build = spec
+	}
+
 	if pipeline.Name == "" && pipeline.Alias == "" {
 		return nil, httperror.ErrorValidationFailed.Error("pipeline name and alias", "can not neither be empty")
 	}
@@ -102,6 +128,12 @@ func (m *pipelineManager) CreatePipeline(projectName string, pipeline *api.Pipel
 		}
 	}
 
+	if pipeline.AutoTrigger != nil {
+		if err := event.LintSCMTrigger(pipeline.AutoTrigger.SCMTrigger); err != nil {
+			return nil, httperror.ErrorValidationFailed.Error("autoTrigger", err.Error())
+		}
+	}
+
 	scmConfig, err := m.GetSCMConfigFromProject(projectName)
 	if err != nil {
 		return nil, err
@@ -117,31 +149,45 @@ func (m *pipelineManager) CreatePipeline(projectName string, pipeline *api.Pipel
 		return nil, err
 	}
 
-	// Create SCM webhook if enable SCM trigger.
-	err = createWebhook(pipeline, provider, scmConfig.Type, gitSource.Url, "")
-	if err != nil {
-		log.Errorf("create webhook failed: %v", err)
+	if pipeline.ID == "" {
+		pipeline.ID = bson.NewObjectId().Hex()
+	}
+
+	state := &api.PipelineReconcileState{
+		PipelineID: pipeline.ID,
+		Pipeline:   pipeline,
+	}
+	if err := m.dataStore.SaveReconcileState(state); err != nil {
 		return nil, err
 	}
 
-	// Remove the webhook if there is error.
+	// Roll back whatever partial state exists if any step below fails; once
+	// the pipeline is durably stored the reconcile state is dropped and this
+	// is a no-op.
 	defer func() {
-		if err != nil && gitSource != nil && pipeline.AutoTrigger != nil && pipeline.AutoTrigger.SCMTrigger != nil {
-			if err = provider.DeleteWebHook(gitSource.Url, pipeline.AutoTrigger.SCMTrigger.Webhook); err != nil {
-				log.Errorf("Fail to delete the webhook %s", pipeline.Name)
-			}
+		if err != nil {
+			m.rollbackPipeline(provider, scmConfig, state)
 		}
 	}()
 
-	// set quality gate if codeScan is turned on.
-	codeScan := pipeline.Build.Stages.CodeScan
-	if codeScan != nil && codeScan.SonarQube != nil && codeScan.SonarQube.Config != nil &&
-		codeScan.SonarQube.Config.Threshold > 0 {
-		if pipeline.ID == "" {
-			pipeline.ID = bson.NewObjectId().Hex()
+	// Create SCM webhook if enable SCM trigger. Safe to call repeatedly: a
+	// hook already registered for this URL is treated as success.
+	if err = ensureWebhook(pipeline, provider, scmConfig.Type, gitSource.Url, pipeline.ID); err != nil {
+		log.Errorf("create webhook failed: %v", err)
+		return nil, err
+	}
+	state.WebhookCreated = true
+	if err = m.dataStore.SaveReconcileState(state); err != nil {
+		return nil, err
+	}
+
+	// Ensure every configured code scanner (SonarQube, SonarCloud, Trivy,
+	// Snyk, ...) has a project and policy set up.
+	if len(integrate.Scanners(pipeline.Build.Stages.CodeScan)) > 0 {
+		if err = ensureCodeScanners(m.dataStore, pipeline, state); err != nil {
+			return nil, err
 		}
-		err = setSonarQualityGate(m.dataStore, pipeline)
-		if err != nil {
+		if err = m.dataStore.SaveReconcileState(state); err != nil {
 			return nil, err
 		}
 	}
@@ -150,47 +196,64 @@ func (m *pipelineManager) CreatePipeline(projectName string, pipeline *api.Pipel
 	if err != nil {
 		return nil, err
 	}
+	state.Stored = true
+
+	if derr := m.dataStore.DeleteReconcileState(pipeline.ID); derr != nil {
+		log.Warningf("create pipeline %s succeeded but reconcile state cleanup failed: %v", pipeline.Name, derr)
+	}
 
 	return createdPipeline, nil
 }
 
-// setSonarQualityGate create the project if it not exist,
-// then set it's quality gate to specific value.
-func setSonarQualityGate(ds *store.DataStore, pipeline *api.Pipeline) error {
-	itName := pipeline.Build.Stages.CodeScan.SonarQube.Name
-	gateID := pipeline.Build.Stages.CodeScan.SonarQube.Config.Threshold
-	integration, err := ds.GetIntegration(itName)
-	if err != nil {
-		return err
+// ensureCodeScanners ensures a project and policy exist with every code
+// scanner configured on the pipeline's CodeScan stage, dispatching to the
+// registered integrate.CodeScanProvider for each scanner's integration type
+// and skipping steps the reconcile state already marks as done so the saga
+// can resume after a crash.
+func ensureCodeScanners(ds *store.DataStore, pipeline *api.Pipeline, state *api.PipelineReconcileState) error {
+	if state.ScannersEnsured == nil {
+		state.ScannersEnsured = map[string]bool{}
 	}
-
-	sonar := integration.SonarQube
-	if sonar == nil {
-		return fmt.Errorf("get sonar info failed")
+	if state.PoliciesSet == nil {
+		state.PoliciesSet = map[string]bool{}
 	}
 
-	err = integrate.CreateProject(api.IntegrationTypeSonar, sonar.Address, sonar.Token, pipeline.ID, pipeline.Alias)
-	if err != nil {
-		if strings.Contains(err.Error(), "key already exists") {
-			// If project already exist, will return:
-			// {"errors":[{"msg":"Could not create Project, key already exists: project-1"}]}
-			log.Infof("Project %s(%s) already exists.", pipeline.Alias, pipeline.ID)
-		} else {
-			log.Errorf("Create sonar project %s error:%v", pipeline.Alias, err)
+	for _, cfg := range integrate.Scanners(pipeline.Build.Stages.CodeScan) {
+		cfg := cfg
+
+		provider, err := integrate.GetCodeScanProvider(cfg.Type)
+		if err != nil {
 			return err
 		}
-	}
 
-	err = integrate.SetQualityGate(api.IntegrationTypeSonar, sonar.Address, sonar.Token, pipeline.ID, gateID)
-	if err != nil {
-		log.Errorf("Set sonar quality gate %d for project %s failed as %v", gateID, pipeline.ID, err)
-		return err
+		integration, err := ds.GetIntegration(cfg.Name)
+		if err != nil {
+			return err
+		}
+
+		if !state.ScannersEnsured[cfg.Name] {
+			if err := provider.EnsureProject(integration, &cfg, pipeline.ID, pipeline.Alias); err != nil {
+				log.Errorf("ensure code scan project %s(%s) error:%v", pipeline.Alias, cfg.Name, err)
+				return err
+			}
+			state.ScannersEnsured[cfg.Name] = true
+		}
+
+		if !state.PoliciesSet[cfg.Name] {
+			if err := provider.SetPolicy(integration, &cfg, pipeline.ID); err != nil {
+				log.Errorf("set code scan policy for %s(%s) failed as %v", pipeline.Alias, cfg.Name, err)
+				return err
+			}
+			state.PoliciesSet[cfg.Name] = true
+		}
 	}
 
 	return nil
 }
 
-func createWebhook(pipeline *api.Pipeline, provider scm.SCMProvider, scmType api.SCMType, mainRepoUrl, pipelineID string) error {
+// ensureWebhook registers the pipeline's SCM webhook, treating "hook with
+// this URL already exists" as success so the call is safe to repeat.
+func ensureWebhook(pipeline *api.Pipeline, provider scm.SCMProvider, scmType api.SCMType, mainRepoUrl, pipelineID string) error {
 	// Create SCM webhook if enable SCM trigger.
 	if pipeline.AutoTrigger != nil && pipeline.AutoTrigger.SCMTrigger != nil {
 
@@ -203,28 +266,27 @@ func createWebhook(pipeline *api.Pipeline, provider scm.SCMProvider, scmType api
 
 			pipeline.AutoTrigger.SCMTrigger.PostCommit.RepoInfo = repoInfo
 		} else {
-			// GitHub and GitLab webhook
-			if pipelineID == "" {
-				pipeline.ID = bson.NewObjectId().Hex()
-			} else {
-				pipeline.ID = pipelineID
-			}
+			// GitHub, GitLab and Bitbucket webhook
+			pipeline.ID = pipelineID
 
 			webHook := &scm.WebHook{
 				Url:    generateWebhookURL(scmType, pipeline.ID),
 				Events: collectSCMEvents(pipeline.AutoTrigger.SCMTrigger),
 			}
-			if err := provider.CreateWebHook(mainRepoUrl, webHook); err != nil {
+			if err := provider.CreateWebHook(mainRepoUrl, webHook); err != nil && !isAlreadyExists(err) {
 				log.Errorf("create webhook failed: %v", err)
 				scmType := pipeline.Build.Stages.CodeCheckout.MainRepo.Type
 				if (scmType == api.Gitlab && strings.Contains(err.Error(), "403")) ||
-					(scmType == api.Github && strings.Contains(err.Error(), "404")) {
+					(scmType == api.Github && strings.Contains(err.Error(), "404")) ||
+					(scmType == api.Bitbucket && (strings.Contains(err.Error(), "401") ||
+						strings.Contains(err.Error(), "403") || strings.Contains(err.Error(), "404"))) {
 					return httperror.ErrorCreateWebhookPermissionDenied.Error(pipeline.Name)
 				}
 
 				return err
 			}
 			pipeline.AutoTrigger.SCMTrigger.Webhook = webHook.Url
+			pipeline.AutoTrigger.SCMTrigger.WebhookID = webHook.ID
 		}
 
 	}
@@ -232,6 +294,61 @@ func createWebhook(pipeline *api.Pipeline, provider scm.SCMProvider, scmType api
 	return nil
 }
 
+// isAlreadyExists reports whether err indicates the remote side already has
+// the resource Cyclone was about to create, which several SCM/Sonar APIs
+// surface as an error rather than a no-op success.
+func isAlreadyExists(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "already exists") || strings.Contains(msg, "already configured") ||
+		strings.Contains(msg, "hook already exists")
+}
+
+// rollbackPipeline undoes whatever steps of the create saga completed before
+// the failure that state records, leaving no orphan webhook or Sonar project.
+func (m *pipelineManager) rollbackPipeline(provider scm.SCMProvider, scmConfig *api.SCMConfig, state *api.PipelineReconcileState) {
+	pipeline := state.Pipeline
+	if pipeline == nil {
+		return
+	}
+
+	if state.WebhookCreated && pipeline.AutoTrigger != nil && pipeline.AutoTrigger.SCMTrigger != nil {
+		if gitSource, err := api.GetGitSource(pipeline.Build.Stages.CodeCheckout.MainRepo); err == nil {
+			if err := provider.DeleteWebHook(gitSource.Url, pipeline.AutoTrigger.SCMTrigger.WebhookID, pipeline.AutoTrigger.SCMTrigger.Webhook); err != nil {
+				log.Errorf("rollback: fail to delete the webhook for pipeline %s: %v", pipeline.Name, err)
+			}
+		}
+	}
+
+	for _, cfg := range integrate.Scanners(pipeline.Build.Stages.CodeScan) {
+		if !state.ScannersEnsured[cfg.Name] {
+			continue
+		}
+
+		provider, err := integrate.GetCodeScanProvider(cfg.Type)
+		if err != nil {
+			log.Errorf("rollback: no code scan provider for %s: %v", cfg.Name, err)
+			continue
+		}
+
+		integration, err := m.dataStore.GetIntegration(cfg.Name)
+		if err != nil {
+			log.Errorf("rollback: can't load integration %s: %v", cfg.Name, err)
+			continue
+		}
+
+		if err := provider.DeleteProject(integration, pipeline.ID); err != nil {
+			log.Errorf("rollback: fail to delete code scan project %s for pipeline %s: %v", cfg.Name, pipeline.Name, err)
+		}
+	}
+
+	if err := m.dataStore.DeleteReconcileState(pipeline.ID); err != nil {
+		log.Errorf("rollback: fail to clean up reconcile state for pipeline %s: %v", pipeline.Name, err)
+	}
+}
+
 // GetPipeline gets the pipeline by name in one project.
 func (m *pipelineManager) GetPipeline(projectName string, pipelineName string, recentCount, recentSuccessCount, recentFailedCount int) (*api.Pipeline, error) {
 	project, err := m.dataStore.FindProjectByName(projectName)
@@ -366,6 +483,20 @@ func (m *pipelineManager) UpdatePipeline(projectName string, pipelineName string
 		return nil, err
 	}
 
+	if newPipeline.TemplateRef != "" {
+		spec, err := m.RenderTemplate(projectName, newPipeline.TemplateRef, newPipeline.TemplateParams)
+		if err != nil {
+			return nil, err
+		}
+		newPipeline.Build = spec
+	}
+
+	if newPipeline.AutoTrigger != nil {
+		if err := event.LintSCMTrigger(newPipeline.AutoTrigger.SCMTrigger); err != nil {
+			return nil, httperror.ErrorValidationFailed.Error("autoTrigger", err.Error())
+		}
+	}
+
 	scmConfig, err := m.GetSCMConfigFromProject(projectName)
 	if err != nil {
 		return nil, err
@@ -381,18 +512,10 @@ func (m *pipelineManager) UpdatePipeline(projectName string, pipelineName string
 		return nil, err
 	}
 
-	// Remove the old webhook if exists.
-	if pipeline.AutoTrigger != nil && pipeline.AutoTrigger.SCMTrigger != nil {
-		scmTrigger := pipeline.AutoTrigger.SCMTrigger
-		if scmTrigger.Webhook != "" {
-			if err := provider.DeleteWebHook(oldGitSource.Url, scmTrigger.Webhook); err != nil {
-				return nil, err
-			}
-		}
-	}
-
-	var newGitSource *api.GitSource
-	// Use new pipeline git source url to create webhook
+	// newGitSource defaults to oldGitSource when the update doesn't touch
+	// Build at all (e.g. renaming Alias), so the comparison below never
+	// dereferences a nil pointer.
+	newGitSource := oldGitSource
 	if newPipeline.Build != nil && newPipeline.Build.Stages != nil &&
 		newPipeline.Build.Stages.CodeCheckout != nil && newPipeline.Build.Stages.CodeCheckout.MainRepo != nil {
 		newGitSource, err = api.GetGitSource(newPipeline.Build.Stages.CodeCheckout.MainRepo)
@@ -401,31 +524,47 @@ func (m *pipelineManager) UpdatePipeline(projectName string, pipelineName string
 		}
 	}
 
-	// Create the new webhook if necessary.
-	err = createWebhook(newPipeline, provider, scmConfig.Type, newGitSource.Url, pipeline.ID)
-	if err != nil {
-		log.Errorf("create webhook failed: %v, try to rollback", err)
-		// Try to rollback
-		errrb := createWebhook(pipeline, provider, scmConfig.Type, oldGitSource.Url, pipeline.ID)
-		if errrb != nil {
-			log.Warningf("rollback to create old webhook failed: %v", errrb)
+	// Only touch the webhook when the trigger shape actually changed, rather
+	// than unconditionally deleting and recreating it on every update.
+	if autoTriggerChanged(pipeline.AutoTrigger, newPipeline.AutoTrigger) || oldGitSource.Url != newGitSource.Url {
+		if pipeline.AutoTrigger != nil && pipeline.AutoTrigger.SCMTrigger != nil && pipeline.AutoTrigger.SCMTrigger.Webhook != "" {
+			if err := provider.DeleteWebHook(oldGitSource.Url, pipeline.AutoTrigger.SCMTrigger.WebhookID, pipeline.AutoTrigger.SCMTrigger.Webhook); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = ensureWebhook(newPipeline, provider, scmConfig.Type, newGitSource.Url, pipeline.ID); err != nil {
+			log.Errorf("create webhook failed: %v, try to rollback", err)
+			// Try to rollback to the previous webhook so the pipeline isn't left untriggerable.
+			if errrb := ensureWebhook(pipeline, provider, scmConfig.Type, oldGitSource.Url, pipeline.ID); errrb != nil {
+				log.Warningf("rollback to create old webhook failed: %v", errrb)
+			}
+			return nil, err
+		}
+	} else {
+		newPipeline.ID = pipeline.ID
+		if pipeline.AutoTrigger != nil && pipeline.AutoTrigger.SCMTrigger != nil && newPipeline.AutoTrigger != nil && newPipeline.AutoTrigger.SCMTrigger != nil {
+			newPipeline.AutoTrigger.SCMTrigger.Webhook = pipeline.AutoTrigger.SCMTrigger.Webhook
+			newPipeline.AutoTrigger.SCMTrigger.WebhookID = pipeline.AutoTrigger.SCMTrigger.WebhookID
 		}
-		return nil, err
 	}
 
 	pipeline.AutoTrigger = newPipeline.AutoTrigger
 
-	// set quality gate if codeScan is turned on.
+	// Only (re)configure the code scanners whose policy actually changed.
 	if pipeline.Build != nil && pipeline.Build.Stages != nil &&
 		newPipeline.Build != nil && newPipeline.Build.Stages != nil {
-		cs := pipeline.Build.Stages.CodeScan
-		newcs := newPipeline.Build.Stages.CodeScan
-
-		if newcs != nil && newcs.SonarQube != nil && newcs.SonarQube.Config != nil && newcs.SonarQube.Config.Threshold > 0 &&
-			(cs == nil || cs.SonarQube.Config.Threshold != newcs.SonarQube.Config.Threshold) {
+		newScanners := integrate.Scanners(newPipeline.Build.Stages.CodeScan)
+		if len(newScanners) > 0 {
 			newPipeline.ID = pipeline.ID
-			err = setSonarQualityGate(m.dataStore, newPipeline)
-			if err != nil {
+			ensured, policies := diffScannerState(pipeline.Build.Stages.CodeScan, newScanners)
+			state := &api.PipelineReconcileState{
+				PipelineID:      pipeline.ID,
+				Pipeline:        newPipeline,
+				ScannersEnsured: ensured,
+				PoliciesSet:     policies,
+			}
+			if err = ensureCodeScanners(m.dataStore, newPipeline, state); err != nil {
 				return nil, err
 			}
 		}
@@ -521,38 +660,95 @@ func (m *pipelineManager) deletePipeline(scmConfig *api.SCMConfig, pipeline *api
 			return nil
 		}
 
-		if err := provider.DeleteWebHook(gitSource.Url, pipeline.AutoTrigger.SCMTrigger.Webhook); err != nil {
+		if err := provider.DeleteWebHook(gitSource.Url, pipeline.AutoTrigger.SCMTrigger.WebhookID, pipeline.AutoTrigger.SCMTrigger.Webhook); err != nil {
 			log.Warningf("Fail to delete webhook for pipeline %s", pipeline.Name)
 			return nil
 		}
 	}
 
-	// Delete sonar project
-	if pipeline.Build != nil && pipeline.Build.Stages != nil &&
-		pipeline.Build.Stages.CodeScan != nil && pipeline.Build.Stages.CodeScan.SonarQube != nil {
-		sonar := pipeline.Build.Stages.CodeScan.SonarQube
-		it, err := m.dataStore.GetIntegration(sonar.Name)
-		if err != nil {
-			log.Warningf("Delete pipeline %s, can not get integration info for %s", pipeline.Name, sonar.Name)
-			return nil
-		}
+	// Delete the project for every configured code scanner.
+	if pipeline.Build != nil && pipeline.Build.Stages != nil {
+		for _, cfg := range integrate.Scanners(pipeline.Build.Stages.CodeScan) {
+			provider, err := integrate.GetCodeScanProvider(cfg.Type)
+			if err != nil {
+				log.Warningf("Delete pipeline %s, no code scan provider for %s", pipeline.Name, cfg.Name)
+				continue
+			}
 
-		sonarInfo := it.SonarQube
-		if sonarInfo == nil {
-			log.Warningf("Delete pipeline %s, integration info for %s is empty", pipeline.Name, sonar.Name)
-			return nil
-		}
+			it, err := m.dataStore.GetIntegration(cfg.Name)
+			if err != nil {
+				log.Warningf("Delete pipeline %s, can not get integration info for %s", pipeline.Name, cfg.Name)
+				continue
+			}
 
-		err = integrate.DeleteProject(api.IntegrationTypeSonar, sonarInfo.Address, sonarInfo.Token, pipeline.ID)
-		if err != nil {
-			log.Warningf("Delete pipeline %s, delete sonar qube project failed", pipeline.Name)
-			return nil
+			if err := provider.DeleteProject(it, pipeline.ID); err != nil {
+				log.Warningf("Delete pipeline %s, delete code scan project %s failed", pipeline.Name, cfg.Name)
+			}
 		}
 	}
 
 	return nil
 }
 
+// diffScannerState compares the code scanners configured before an update
+// against the new set, so ensureCodeScanners only (re)creates projects that
+// are genuinely new and only reapplies policy for scanners whose threshold
+// changed, instead of unconditionally redoing all of it on every update.
+func diffScannerState(oldCodeScan *api.CodeScan, newScanners []api.CodeScanConfig) (ensured, policies map[string]bool) {
+	old := make(map[string]api.CodeScanConfig, len(integrate.Scanners(oldCodeScan)))
+	for _, cfg := range integrate.Scanners(oldCodeScan) {
+		old[cfg.Name] = cfg
+	}
+
+	ensured = map[string]bool{}
+	policies = map[string]bool{}
+	for _, cfg := range newScanners {
+		oldCfg, existed := old[cfg.Name]
+		ensured[cfg.Name] = existed
+		policies[cfg.Name] = existed && oldCfg.Threshold == cfg.Threshold
+	}
+
+	return ensured, policies
+}
+
+// autoTriggerChanged reports whether the SCM trigger shape differs enough
+// between old and new to require re-registering the webhook, i.e. the set of
+// subscribed event subtypes changed.
+func autoTriggerChanged(old, new *api.AutoTrigger) bool {
+	var oldTrigger, newTrigger *api.SCMTrigger
+	if old != nil {
+		oldTrigger = old.SCMTrigger
+	}
+	if new != nil {
+		newTrigger = new.SCMTrigger
+	}
+
+	if (oldTrigger == nil) != (newTrigger == nil) {
+		return true
+	}
+	if oldTrigger == nil {
+		return false
+	}
+
+	oldEvents := collectSCMEvents(oldTrigger)
+	newEvents := collectSCMEvents(newTrigger)
+	if len(oldEvents) != len(newEvents) {
+		return true
+	}
+
+	seen := make(map[scm.EventType]bool, len(oldEvents))
+	for _, e := range oldEvents {
+		seen[e] = true
+	}
+	for _, e := range newEvents {
+		if !seen[e] {
+			return true
+		}
+	}
+
+	return false
+}
+
 func generateWebhookURL(scmType api.SCMType, pipelineID string) string {
 	callbackURL := osutil.GetStringEnv(options.CallbackURL, "http://127.0.0.1:7099/v1/pipelines")
 	callbackURL = strings.TrimSuffix(callbackURL, "/")
@@ -581,110 +777,78 @@ func collectSCMEvents(scmTrigger *api.SCMTrigger) []scm.EventType {
 	return events
 }
 
-// GetSCMConfigFromProject
-func (m *pipelineManager) GetSCMConfigFromProject(projectName string) (*api.SCMConfig, error) {
-	// Get the SCM config from project.
-	project, err := m.dataStore.FindProjectByName(projectName)
+// BuildRecordEnvVars resolves record's git source and returns the
+// CYCLONE_*/CI_* env vars a stage container for it should get, with the
+// clone URL signed via the pipeline's SCM provider so a private-mode repo can
+// be cloned without a deploy key. This snapshot doesn't include the pipeline
+// record state machine (it lives with PipelineRecordManager, defined outside
+// this tree); that's the intended caller, once per record before dispatching
+// its first stage.
+func (m *pipelineManager) BuildRecordEnvVars(pipeline *api.Pipeline, record *api.PipelineRecord) (map[string]string, error) {
+	gitSource, err := api.GetGitSource(pipeline.Build.Stages.CodeCheckout.MainRepo)
 	if err != nil {
-		if err == mgo.ErrNotFound {
-			return nil, httperror.ErrorContentNotFound.Error(projectName)
-		}
-
 		return nil, err
 	}
 
-	return project.SCM, nil
-}
-
-/// GetStatistics gets the statistic by pipeline name.
-func (m *pipelineManager) GetStatistics(projectName, pipelineName string, start, end time.Time) (*api.PipelineStatusStats, error) {
-	pipeline, err := m.GetPipeline(projectName, pipelineName, 0, 0, 0)
+	project, err := m.dataStore.FindProjectByID(pipeline.ProjectID)
 	if err != nil {
-		if err == mgo.ErrNotFound {
-			return nil, httperror.ErrorContentNotFound.Error(projectName)
-		}
-
 		return nil, err
 	}
 
-	// find all records ( start<={records}.startTime<end && {records}.pipelineID=pipeline.ID )
-	records, _, err := m.dataStore.FindPipelineRecordsByStartTime(pipeline.ID, start, end)
+	provider, err := scm.GetSCMProvider(project.SCM)
 	if err != nil {
 		return nil, err
 	}
 
-	return transRecordsToStats(records, start, end)
+	return BuildPipelineEnvVars(pipeline, record, provider.SignCloneURL(gitSource.Url)), nil
 }
 
-func transRecordsToStats(records []api.PipelineRecord, start, end time.Time) (*api.PipelineStatusStats, error) {
-	statistics := &api.PipelineStatusStats{
-		Overview: api.StatsOverview{
-			Total:        len(records),
-			SuccessRatio: "0.00%",
-		},
-		Details: []*api.StatsDetail{},
+// ReportRecordStatus posts record's current status back to the commit (and,
+// for PR-triggered records, the pull request) that triggered it, via the
+// project's configured SCM provider. A failed report is logged by
+// StatusReporter and never propagated back to the caller, since it must
+// never fail or retry the pipeline run itself. This snapshot doesn't include
+// the pipeline record state machine (it lives with PipelineRecordManager,
+// defined outside this tree); that's the intended caller, on every status
+// transition.
+func (m *pipelineManager) ReportRecordStatus(projectName string, pipeline *api.Pipeline, record *api.PipelineRecord, targetURL string) error {
+	scmConfig, err := m.GetSCMConfigFromProject(projectName)
+	if err != nil {
+		return err
 	}
 
-	initStatsDetails(statistics, start, end)
-
-	for _, record := range records {
-		for _, detail := range statistics.Details {
-			if detail.Timestamp == formatTimeToDay(record.StartTime) {
-				// set details status
-				detail.StatsStatus = statsStatus(detail.StatsStatus, record.Status)
-			}
-
-		}
-
-		// set overview status
-		statistics.Overview.StatsStatus = statsStatus(statistics.Overview.StatsStatus, record.Status)
+	provider, err := scm.GetSCMProvider(scmConfig)
+	if err != nil {
+		return err
 	}
 
-	if statistics.Overview.Total != 0 {
-		statistics.Overview.SuccessRatio = fmt.Sprintf("%.2f%%",
-			float64(statistics.Overview.Success)/float64(statistics.Overview.Total)*100)
+	gitSource, err := api.GetGitSource(pipeline.Build.Stages.CodeCheckout.MainRepo)
+	if err != nil {
+		return err
 	}
-	return statistics, nil
-}
 
-func formatTimeToDay(t time.Time) int64 {
-	timestamp := t.Unix()
-	return timestamp - (timestamp % 86400)
-}
-
-func statsStatus(s api.StatsStatus, recordStatus api.Status) api.StatsStatus {
-	switch recordStatus {
-	case api.Success:
-		s.Success++
-	case api.Failed:
-		s.Failed++
-	case api.Aborted:
-		s.Aborted++
-	default:
+	reporter := NewStatusReporter(provider)
+	reporter.Report(gitSource.Url, record.CommitSha, record.Status, targetURL)
+	if record.PRNumber != 0 {
+		reporter.ReportPullRequestComment(gitSource.Url, record.PRNumber, record.PipelineID, record.Status, targetURL, record.FailingStage)
 	}
 
-	return s
+	return nil
 }
 
-func initStatsDetails(statistics *api.PipelineStatusStats, start, end time.Time) {
-	for ; !start.After(end); start = start.Add(24 * time.Hour) {
-		detail := &api.StatsDetail{
-			Timestamp: formatTimeToDay(start),
+// GetSCMConfigFromProject
+func (m *pipelineManager) GetSCMConfigFromProject(projectName string) (*api.SCMConfig, error) {
+	// Get the SCM config from project.
+	project, err := m.dataStore.FindProjectByName(projectName)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, httperror.ErrorContentNotFound.Error(projectName)
 		}
-		statistics.Details = append(statistics.Details, detail)
-	}
 
-	// if last day not equal end day, append end day.
-	endDay := formatTimeToDay(end)
-	length := len(statistics.Details)
-	if length > 0 {
-		if statistics.Details[length-1].Timestamp != endDay {
-			detail := &api.StatsDetail{
-				Timestamp: endDay,
-			}
-			statistics.Details = append(statistics.Details, detail)
-		}
+		return nil, err
 	}
+
+	return project.SCM, nil
 }
 
 func (m *pipelineManager) DeletePipelineLogs(pipelineID string) error {
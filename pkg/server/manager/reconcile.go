@@ -0,0 +1,142 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/integrate"
+	"github.com/caicloud/cyclone/pkg/scm"
+)
+
+// reconcileInFlightPipelines runs once at manager startup and finishes or
+// rolls back any pipeline whose create saga was interrupted by a crash,
+// i.e. a PipelineReconcileState doc that was never cleaned up because
+// CreatePipeline didn't reach its final "stored" step.
+func (m *pipelineManager) reconcileInFlightPipelines() {
+	states, err := m.dataStore.ListInFlightReconcileStates()
+	if err != nil {
+		log.Errorf("reconcile: fail to list in-flight pipeline reconcile states: %v", err)
+		return
+	}
+
+	for i := range states {
+		m.reconcileOne(&states[i])
+	}
+}
+
+// reconcileOne finishes the saga for a single in-flight pipeline if it's
+// recoverable, or rolls back its partial side effects otherwise.
+func (m *pipelineManager) reconcileOne(state *api.PipelineReconcileState) {
+	pipeline := state.Pipeline
+	if pipeline == nil {
+		log.Warningf("reconcile: state for pipeline %s has no pipeline snapshot, dropping", state.PipelineID)
+		if err := m.dataStore.DeleteReconcileState(state.PipelineID); err != nil {
+			log.Errorf("reconcile: fail to drop orphan reconcile state %s: %v", state.PipelineID, err)
+		}
+		return
+	}
+
+	if state.Stored {
+		// The store insert itself already completed; the state doc just
+		// wasn't cleaned up. Nothing left to reconcile.
+		if err := m.dataStore.DeleteReconcileState(state.PipelineID); err != nil {
+			log.Errorf("reconcile: fail to clean up completed reconcile state for pipeline %s: %v", pipeline.Name, err)
+		}
+		return
+	}
+
+	project, err := m.dataStore.FindProjectByID(pipeline.ProjectID)
+	if err != nil {
+		log.Warningf("reconcile: can't load project for pipeline %s, rolling back: %v", pipeline.Name, err)
+		m.rollbackReconcileState(state)
+		return
+	}
+
+	provider, err := scm.GetSCMProvider(project.SCM)
+	if err != nil {
+		log.Warningf("reconcile: can't get SCM provider for pipeline %s, rolling back: %v", pipeline.Name, err)
+		m.rollbackReconcileState(state)
+		return
+	}
+
+	gitSource, err := api.GetGitSource(pipeline.Build.Stages.CodeCheckout.MainRepo)
+	if err != nil {
+		log.Warningf("reconcile: can't resolve git source for pipeline %s, rolling back: %v", pipeline.Name, err)
+		m.rollbackReconcileState(state)
+		return
+	}
+
+	if !state.WebhookCreated {
+		if err := ensureWebhook(pipeline, provider, project.SCM.Type, gitSource.Url, pipeline.ID); err != nil {
+			log.Warningf("reconcile: fail to finish webhook creation for pipeline %s, rolling back: %v", pipeline.Name, err)
+			m.rollbackReconcileState(state)
+			return
+		}
+		state.WebhookCreated = true
+		if err := m.dataStore.SaveReconcileState(state); err != nil {
+			log.Errorf("reconcile: fail to persist progress for pipeline %s: %v", pipeline.Name, err)
+		}
+	}
+
+	if len(integrate.Scanners(pipeline.Build.Stages.CodeScan)) > 0 {
+		if err := ensureCodeScanners(m.dataStore, pipeline, state); err != nil {
+			log.Warningf("reconcile: fail to finish code scan setup for pipeline %s, rolling back: %v", pipeline.Name, err)
+			m.rollbackReconcileState(state)
+			return
+		}
+		if err := m.dataStore.SaveReconcileState(state); err != nil {
+			log.Errorf("reconcile: fail to persist progress for pipeline %s: %v", pipeline.Name, err)
+		}
+	}
+
+	if _, err := m.dataStore.CreatePipeline(pipeline); err != nil {
+		log.Warningf("reconcile: fail to finish storing pipeline %s, rolling back: %v", pipeline.Name, err)
+		m.rollbackReconcileState(state)
+		return
+	}
+
+	log.Infof("reconcile: resumed and completed pipeline %s", pipeline.Name)
+	if err := m.dataStore.DeleteReconcileState(pipeline.ID); err != nil {
+		log.Errorf("reconcile: fail to clean up reconcile state for pipeline %s: %v", pipeline.Name, err)
+	}
+}
+
+// rollbackReconcileState undoes the side effects recorded by state using the
+// pipeline's own project/SCM config, for cases where reconcileOne can't reuse
+// an already-resolved provider.
+func (m *pipelineManager) rollbackReconcileState(state *api.PipelineReconcileState) {
+	pipeline := state.Pipeline
+	if pipeline == nil {
+		return
+	}
+
+	project, err := m.dataStore.FindProjectByID(pipeline.ProjectID)
+	if err != nil {
+		log.Errorf("reconcile: can't load project to roll back pipeline %s: %v", pipeline.Name, err)
+		return
+	}
+
+	provider, err := scm.GetSCMProvider(project.SCM)
+	if err != nil {
+		log.Errorf("reconcile: can't get SCM provider to roll back pipeline %s: %v", pipeline.Name, err)
+		return
+	}
+
+	m.rollbackPipeline(provider, project.SCM, state)
+}
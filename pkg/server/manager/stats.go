@@ -0,0 +1,330 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/caicloud/nirvana/log"
+	"gopkg.in/mgo.v2"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/store"
+	httperror "github.com/caicloud/cyclone/pkg/util/http/errors"
+)
+
+// StatsGranularity is the bucket size GetStatistics aggregates by.
+type StatsGranularity string
+
+// Supported statistics granularities.
+const (
+	GranularityHour StatsGranularity = "hour"
+	GranularityDay  StatsGranularity = "day"
+	GranularityWeek StatsGranularity = "week"
+)
+
+// bucketWindow returns the duration of a single bucket for g.
+func (g StatsGranularity) bucketWindow() time.Duration {
+	switch g {
+	case GranularityHour:
+		return time.Hour
+	case GranularityWeek:
+		return 7 * 24 * time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// GetStatistics gets the statistics for a pipeline over [start, end]. It
+// serves from the materialized pipeline_stats_buckets collection when
+// buckets at the requested granularity are available, falling back to
+// aggregating PipelineRecords directly (the only option for ranges that
+// predate the buckets collection, or environments that haven't backfilled
+// it yet).
+func (m *pipelineManager) GetStatistics(projectName, pipelineName string, start, end time.Time, granularity StatsGranularity) (*api.PipelineStatusStats, error) {
+	if granularity == "" {
+		granularity = GranularityDay
+	}
+
+	pipeline, err := m.GetPipeline(projectName, pipelineName, 0, 0, 0)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, httperror.ErrorContentNotFound.Error(projectName)
+		}
+
+		return nil, err
+	}
+
+	buckets, err := m.dataStore.FindStatsBuckets(pipeline.ID, start, end, string(granularity))
+	if err != nil {
+		log.Warningf("fail to load stats buckets for pipeline %s, falling back to live aggregation: %v", pipeline.Name, err)
+	}
+	if len(buckets) > 0 {
+		return statsFromBuckets(buckets, start, end, granularity), nil
+	}
+
+	// find all records ( start<={records}.startTime<end && {records}.pipelineID=pipeline.ID )
+	records, _, err := m.dataStore.FindPipelineRecordsByStartTime(pipeline.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return transRecordsToStats(records, start, end, granularity), nil
+}
+
+// statsFromBuckets rolls up the pre-aggregated pipeline_stats_buckets docs
+// covering [start, end] into a PipelineStatusStats response.
+func statsFromBuckets(buckets []api.PipelineStatsBucket, start, end time.Time, granularity StatsGranularity) *api.PipelineStatusStats {
+	statistics := &api.PipelineStatusStats{
+		Overview: api.StatsOverview{SuccessRatio: "0.00%"},
+		Details:  []*api.StatsDetail{},
+	}
+
+	var durations []time.Duration
+	var lastFailure, nextRecovery time.Time
+	var mttrSamples []time.Duration
+
+	for _, b := range buckets {
+		detail := &api.StatsDetail{
+			Timestamp:   b.Timestamp,
+			StatsStatus: api.StatsStatus{Success: b.Success, Failed: b.Failed, Aborted: b.Aborted},
+		}
+		statistics.Details = append(statistics.Details, detail)
+
+		statistics.Overview.Success += b.Success
+		statistics.Overview.Failed += b.Failed
+		statistics.Overview.Aborted += b.Aborted
+		statistics.Overview.Total += b.Success + b.Failed + b.Aborted
+
+		durations = append(durations, b.DurationSamples...)
+
+		if b.Failed > 0 {
+			lastFailure = time.Unix(b.Timestamp, 0)
+		} else if b.Success > 0 && !lastFailure.IsZero() {
+			nextRecovery = time.Unix(b.Timestamp, 0)
+			mttrSamples = append(mttrSamples, nextRecovery.Sub(lastFailure))
+			lastFailure = time.Time{}
+		}
+	}
+
+	if statistics.Overview.Total != 0 {
+		statistics.Overview.SuccessRatio = fmt.Sprintf("%.2f%%",
+			float64(statistics.Overview.Success)/float64(statistics.Overview.Total)*100)
+		statistics.Overview.ChangeFailureRate = float64(statistics.Overview.Failed) / float64(statistics.Overview.Total)
+	}
+
+	statistics.Overview.DurationP50 = percentile(durations, 0.50)
+	statistics.Overview.DurationP90 = percentile(durations, 0.90)
+	statistics.Overview.DurationP99 = percentile(durations, 0.99)
+	statistics.Overview.MTTR = meanDuration(mttrSamples)
+
+	return statistics
+}
+
+// transRecordsToStats is the live-aggregation fallback: it computes the same
+// overview/detail shape as statsFromBuckets directly from PipelineRecords,
+// for ranges the buckets collection hasn't covered yet.
+func transRecordsToStats(records []api.PipelineRecord, start, end time.Time, granularity StatsGranularity) *api.PipelineStatusStats {
+	statistics := &api.PipelineStatusStats{
+		Overview: api.StatsOverview{
+			Total:        len(records),
+			SuccessRatio: "0.00%",
+		},
+		Details: []*api.StatsDetail{},
+	}
+
+	initStatsDetails(statistics, start, end, granularity)
+
+	var durations []time.Duration
+	var queueTimes []time.Duration
+	var lastFailure time.Time
+	var mttrSamples []time.Duration
+
+	for _, record := range sortByStartTime(records) {
+		bucket := bucketTimestamp(record.StartTime, granularity)
+		for _, detail := range statistics.Details {
+			if detail.Timestamp == bucket {
+				detail.StatsStatus = statsStatus(detail.StatsStatus, record.Status)
+			}
+		}
+
+		statistics.Overview.StatsStatus = statsStatus(statistics.Overview.StatsStatus, record.Status)
+
+		if !record.EndTime.IsZero() && !record.StartTime.IsZero() {
+			durations = append(durations, record.EndTime.Sub(record.StartTime))
+		}
+		if !record.RunningTime.IsZero() && !record.StartTime.IsZero() {
+			queueTimes = append(queueTimes, record.RunningTime.Sub(record.StartTime))
+		}
+
+		switch record.Status {
+		case api.Failed:
+			lastFailure = record.StartTime
+		case api.Success:
+			if !lastFailure.IsZero() {
+				mttrSamples = append(mttrSamples, record.StartTime.Sub(lastFailure))
+				lastFailure = time.Time{}
+			}
+		}
+	}
+
+	if statistics.Overview.Total != 0 {
+		statistics.Overview.SuccessRatio = fmt.Sprintf("%.2f%%",
+			float64(statistics.Overview.Success)/float64(statistics.Overview.Total)*100)
+		statistics.Overview.ChangeFailureRate = float64(statistics.Overview.Failed) / float64(statistics.Overview.Total)
+	}
+
+	statistics.Overview.DurationP50 = percentile(durations, 0.50)
+	statistics.Overview.DurationP90 = percentile(durations, 0.90)
+	statistics.Overview.DurationP99 = percentile(durations, 0.99)
+	statistics.Overview.MeanQueueTime = meanDuration(queueTimes)
+	statistics.Overview.MTTR = meanDuration(mttrSamples)
+
+	return statistics
+}
+
+func sortByStartTime(records []api.PipelineRecord) []api.PipelineRecord {
+	sorted := make([]api.PipelineRecord, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+	return sorted
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of a set of durations,
+// or 0 if there are none.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(p * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+
+	return sorted[idx]
+}
+
+func meanDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+
+	return total / time.Duration(len(durations))
+}
+
+func bucketTimestamp(t time.Time, granularity StatsGranularity) int64 {
+	window := int64(granularity.bucketWindow() / time.Second)
+	timestamp := t.Unix()
+	return timestamp - (timestamp % window)
+}
+
+func statsStatus(s api.StatsStatus, recordStatus api.Status) api.StatsStatus {
+	switch recordStatus {
+	case api.Success:
+		s.Success++
+	case api.Failed:
+		s.Failed++
+	case api.Aborted:
+		s.Aborted++
+	default:
+	}
+
+	return s
+}
+
+func initStatsDetails(statistics *api.PipelineStatusStats, start, end time.Time, granularity StatsGranularity) {
+	window := granularity.bucketWindow()
+	for t := start; !t.After(end); t = t.Add(window) {
+		detail := &api.StatsDetail{
+			Timestamp: bucketTimestamp(t, granularity),
+		}
+		statistics.Details = append(statistics.Details, detail)
+	}
+
+	// if last bucket doesn't cover the end day, append the end bucket.
+	endBucket := bucketTimestamp(end, granularity)
+	length := len(statistics.Details)
+	if length > 0 && statistics.Details[length-1].Timestamp != endBucket {
+		statistics.Details = append(statistics.Details, &api.StatsDetail{Timestamp: endBucket})
+	}
+}
+
+// RecordStatsForTerminalRecord folds record into its pipeline_stats_buckets
+// documents at every granularity GetStatistics's fast path serves from. It's
+// a no-op for any status but Success/Failed/Aborted, so it's safe for a
+// caller to invoke on every status transition rather than only the terminal
+// one. This snapshot doesn't include the pipeline record state machine (it
+// lives with PipelineRecordManager, defined outside this tree); that's the
+// intended caller, once record reaches a terminal status.
+func (m *pipelineManager) RecordStatsForTerminalRecord(record *api.PipelineRecord) error {
+	switch record.Status {
+	case api.Success, api.Failed, api.Aborted:
+	default:
+		return nil
+	}
+
+	for _, granularity := range []StatsGranularity{GranularityHour, GranularityDay, GranularityWeek} {
+		if err := UpdateStatsBucket(m.dataStore, record, granularity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateStatsBucket folds a just-completed PipelineRecord into its
+// pipeline_stats_buckets doc for the given granularity, creating the bucket
+// if it doesn't exist yet. RecordStatsForTerminalRecord calls this once per
+// granularity for every terminal record, which is what keeps GetStatistics's
+// fast path from drifting out of date.
+func UpdateStatsBucket(ds *store.DataStore, record *api.PipelineRecord, granularity StatsGranularity) error {
+	bucket, err := ds.GetStatsBucket(record.PipelineID, bucketTimestamp(record.StartTime, granularity), string(granularity))
+	if err != nil {
+		bucket = &api.PipelineStatsBucket{
+			PipelineID:  record.PipelineID,
+			Timestamp:   bucketTimestamp(record.StartTime, granularity),
+			Granularity: string(granularity),
+		}
+	}
+
+	switch record.Status {
+	case api.Success:
+		bucket.Success++
+	case api.Failed:
+		bucket.Failed++
+	case api.Aborted:
+		bucket.Aborted++
+	}
+
+	if !record.EndTime.IsZero() && !record.StartTime.IsZero() {
+		bucket.DurationSamples = append(bucket.DurationSamples, record.EndTime.Sub(record.StartTime))
+	}
+
+	return ds.UpsertStatsBucket(bucket)
+}
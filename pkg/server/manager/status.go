@@ -0,0 +1,123 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/scm"
+)
+
+// StatusReporter posts a pipeline record's status back to the commit it was
+// triggered from, via the originating SCM's commit/build status API.
+// pipelineManager.ReportRecordStatus calls Report on every status transition
+// (pending, running, success, failure, error, cancelled), not just on
+// terminal success, so a PR's status checks reflect the pipeline's current
+// state throughout the run.
+type StatusReporter struct {
+	provider scm.SCMProvider
+}
+
+// NewStatusReporter builds a StatusReporter bound to a specific SCM provider.
+func NewStatusReporter(provider scm.SCMProvider) *StatusReporter {
+	return &StatusReporter{provider: provider}
+}
+
+// Report posts a single status transition. Like the per-provider
+// CreateStatus it replaces, a failed status post is logged and swallowed
+// rather than propagated: it must never fail or retry the pipeline run
+// itself.
+func (r *StatusReporter) Report(repoURL, commitSha string, status api.Status, targetURL string) {
+	if r.provider == nil || commitSha == "" {
+		return
+	}
+
+	state, description := reportedStatus(status)
+	if err := r.provider.SetCommitStatus(repoURL, commitSha, state, targetURL, description); err != nil {
+		log.Errorf("report %s status for commit %s failed: %v", status, commitSha, err)
+	}
+}
+
+// ReportPullRequestComment posts a single rolling comment on the pull
+// request that triggered a pipeline record, summarizing the build state, a
+// link back to the pipeline console, and the stage that failed if any. It's
+// tagged with a hidden cyclone-id marker, and every call upserts by that
+// marker via UpsertPullRequestComment, so repeated status transitions edit
+// the same comment in place instead of spamming the PR with a new one on
+// every change.
+func (r *StatusReporter) ReportPullRequestComment(repoURL string, prNumber int, pipelineID string, status api.Status, targetURL, failingStage string) {
+	if r.provider == nil || prNumber == 0 {
+		return
+	}
+
+	body := pullRequestCommentBody(pipelineID, status, targetURL, failingStage)
+	if err := r.provider.UpsertPullRequestComment(repoURL, prNumber, cyclonePRCommentMarker(pipelineID), body); err != nil {
+		log.Errorf("post pull request comment for pipeline %s failed: %v", pipelineID, err)
+	}
+}
+
+// cyclonePRCommentMarker is the hidden marker a rolling comment is tagged
+// with, so a future upsert pass can find and edit it rather than appending a
+// new comment on every status transition.
+func cyclonePRCommentMarker(pipelineID string) string {
+	return fmt.Sprintf("<!-- cyclone-id:%s -->", pipelineID)
+}
+
+// pullRequestCommentBody renders the rolling comment body for a pipeline
+// status transition.
+func pullRequestCommentBody(pipelineID string, status api.Status, targetURL, failingStage string) string {
+	_, description := reportedStatus(status)
+
+	var b strings.Builder
+	b.WriteString(cyclonePRCommentMarker(pipelineID))
+	b.WriteString("\n")
+	b.WriteString(fmt.Sprintf("**%s**\n\n", description))
+	if failingStage != "" {
+		b.WriteString(fmt.Sprintf("Failing stage: `%s`\n\n", failingStage))
+	}
+	if targetURL != "" {
+		b.WriteString(fmt.Sprintf("[View details](%s)\n", targetURL))
+	}
+
+	return b.String()
+}
+
+// reportedStatus maps a pipeline record status to the canonical commit
+// status vocabulary every SetCommitStatus implementation understands
+// (pending/running/success/failure/error/cancelled), plus a human
+// description, so providers with a narrower native vocabulary (Bitbucket has
+// no distinct "error") still get something sensible.
+func reportedStatus(status api.Status) (state, description string) {
+	switch status {
+	case api.Pending:
+		return "pending", "Cyclone pipeline is queued"
+	case api.Running:
+		return "running", "Cyclone pipeline is running"
+	case api.Success:
+		return "success", "Cyclone pipeline succeeded"
+	case api.Failed:
+		return "failure", "Cyclone pipeline failed"
+	case api.Aborted:
+		return "cancelled", "Cyclone pipeline was cancelled"
+	default:
+		return "error", fmt.Sprintf("Cyclone pipeline status: %s", status)
+	}
+}
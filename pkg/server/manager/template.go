@@ -0,0 +1,198 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"text/template"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/integrate"
+	"github.com/caicloud/cyclone/pkg/scm"
+	httperror "github.com/caicloud/cyclone/pkg/util/http/errors"
+)
+
+// templateVarPattern matches the simple "${var}" substitution syntax
+// RenderTemplate supports alongside Go templates.
+var templateVarPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// CreateTemplate creates a project-scoped pipeline template. The template's
+// BuildSpec is stored as-is; it's only rendered (and thus validated against
+// its declared parameters) on use, by RenderTemplate.
+func (m *pipelineManager) CreateTemplate(projectName string, template *api.PipelineTemplate) (*api.PipelineTemplate, error) {
+	if template.Name == "" {
+		return nil, httperror.ErrorValidationFailed.Error("name", "can not be empty")
+	}
+
+	project, err := m.dataStore.FindProjectByName(projectName)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, httperror.ErrorContentNotFound.Error(projectName)
+		}
+		return nil, err
+	}
+
+	if _, err := m.dataStore.FindPipelineTemplateByName(project.ID, template.Name); err == nil {
+		return nil, httperror.ErrorAlreadyExist.Error(template.Name)
+	}
+
+	template.ProjectID = project.ID
+
+	return m.dataStore.CreatePipelineTemplate(template)
+}
+
+// ListTemplates lists every pipeline template scoped to a project.
+func (m *pipelineManager) ListTemplates(projectName string) ([]api.PipelineTemplate, error) {
+	project, err := m.dataStore.FindProjectByName(projectName)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, httperror.ErrorContentNotFound.Error(projectName)
+		}
+		return nil, err
+	}
+
+	return m.dataStore.FindPipelineTemplatesByProjectID(project.ID)
+}
+
+// RenderTemplate renders a project's named template with params into a
+// BuildSpec. Templates are authored either as Go templates (referencing
+// ".Foo") or with the simpler "${foo}" substitution syntax; RenderTemplate
+// detects which one a template uses by whether it contains "${".
+func (m *pipelineManager) RenderTemplate(projectName, templateName string, params map[string]string) (*api.BuildSpec, error) {
+	project, err := m.dataStore.FindProjectByName(projectName)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, httperror.ErrorContentNotFound.Error(projectName)
+		}
+		return nil, err
+	}
+
+	pt, err := m.dataStore.FindPipelineTemplateByName(project.ID, templateName)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, httperror.ErrorContentNotFound.Error(templateName)
+		}
+		return nil, err
+	}
+
+	rendered, err := renderTemplateSpec(pt.Spec, params)
+	if err != nil {
+		return nil, httperror.ErrorValidationFailed.Error("templateParams", err.Error())
+	}
+
+	spec := &api.BuildSpec{}
+	if err := json.Unmarshal(rendered, spec); err != nil {
+		return nil, fmt.Errorf("render template %s: %v", templateName, err)
+	}
+
+	return spec, nil
+}
+
+// renderTemplateSpec substitutes params into a raw template spec, using
+// "${var}" replacement if the template contains that syntax, or Go's
+// text/template otherwise.
+func renderTemplateSpec(spec []byte, params map[string]string) ([]byte, error) {
+	if templateVarPattern.Match(spec) {
+		missing := []string{}
+		out := templateVarPattern.ReplaceAllFunc(spec, func(match []byte) []byte {
+			name := templateVarPattern.FindSubmatch(match)[1]
+			value, ok := params[string(name)]
+			if !ok {
+				missing = append(missing, string(name))
+				return match
+			}
+			return []byte(value)
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("missing template params: %v", missing)
+		}
+		return out, nil
+	}
+
+	tmpl, err := template.New("pipelineTemplate").Parse(string(spec))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SwitchPipelineType atomically converts a pipeline between CI-only and
+// CI+CD, preserving its history (records, statistics buckets, reconcile
+// state are all keyed by pipeline ID, which this leaves untouched). Since
+// collectSCMEvents derives the webhook's subscribed events from the
+// pipeline's AutoTrigger rather than its Type, the trigger shape itself
+// never actually changes as a side effect of the switch - but the webhook
+// and every configured code scan integration are re-ensured here anyway via
+// the same idempotent helpers CreatePipeline/reconcileOne use, so a webhook
+// or scan project an earlier operation failed to create for this pipeline
+// gets picked up on the next type switch instead of silently staying broken.
+func (m *pipelineManager) SwitchPipelineType(projectName, pipelineName string, newType api.PipelineType) (*api.Pipeline, error) {
+	pipeline, err := m.GetPipeline(projectName, pipelineName, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if pipeline.Type == newType {
+		return pipeline, nil
+	}
+
+	pipeline.Type = newType
+
+	if pipeline.AutoTrigger != nil && pipeline.AutoTrigger.SCMTrigger != nil {
+		scmConfig, err := m.GetSCMConfigFromProject(projectName)
+		if err != nil {
+			return nil, err
+		}
+
+		scmProvider, err := scm.GetSCMProvider(scmConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		gitSource, err := api.GetGitSource(pipeline.Build.Stages.CodeCheckout.MainRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ensureWebhook(pipeline, scmProvider, scmConfig.Type, gitSource.Url, pipeline.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(integrate.Scanners(pipeline.Build.Stages.CodeScan)) > 0 {
+		if err := ensureCodeScanners(m.dataStore, pipeline, &api.PipelineReconcileState{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.dataStore.UpdatePipeline(pipeline); err != nil {
+		return nil, err
+	}
+
+	return pipeline, nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2017 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+
+	"github.com/caicloud/cyclone/pkg/api"
+	"github.com/caicloud/cyclone/pkg/event"
+	"github.com/caicloud/cyclone/pkg/scm"
+)
+
+// HandleSCMWebhook is the entry point the webhook HTTP route calls with a
+// parsed incoming SCM event, before a pipeline record is created for it. It
+// resolves the trigger subtype eventType maps to and evaluates its
+// event.WebhookFilter against vars/rawPayload, so a push to a branch/path or
+// a tag that doesn't match the pipeline's configured filter never reaches
+// eventManager and never creates a record - LintSCMTrigger only rejects a
+// malformed filter at save time, it doesn't evaluate it against traffic.
+// It returns matched=false, err=nil (not an error) when the trigger subtype
+// isn't enabled or the filter didn't match.
+func (m *pipelineManager) HandleSCMWebhook(pipelineID string, eventType scm.EventType, vars event.FilterVars, rawPayload interface{}) (matched bool, err error) {
+	pipeline, err := m.GetPipelineByID(pipelineID)
+	if err != nil {
+		return false, err
+	}
+
+	if pipeline.AutoTrigger == nil || pipeline.AutoTrigger.SCMTrigger == nil {
+		return false, nil
+	}
+	trigger := pipeline.AutoTrigger.SCMTrigger
+
+	var filter *api.WebhookFilter
+	switch eventType {
+	case scm.PushEventType:
+		if trigger.Push == nil {
+			return false, nil
+		}
+		filter = trigger.Push.Filter
+	case scm.PullRequestEventType:
+		if trigger.PullRequest == nil {
+			return false, nil
+		}
+		filter = trigger.PullRequest.Filter
+	case scm.PullRequestCommentEventType:
+		if trigger.PullRequestComment == nil {
+			return false, nil
+		}
+		filter = trigger.PullRequestComment.Filter
+	case scm.TagReleaseEventType:
+		if trigger.TagRelease == nil {
+			return false, nil
+		}
+		filter = trigger.TagRelease.Filter
+	default:
+		return false, fmt.Errorf("unsupported webhook event type %s", eventType)
+	}
+
+	matched, err = event.MatchWebhookFilter(filter, vars, rawPayload)
+	if err != nil || !matched {
+		return false, err
+	}
+
+	return true, m.eventManager.HandleSCMEvent(pipeline, eventType, vars, rawPayload)
+}